@@ -1,10 +1,281 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+	"github.com/lpicanco/i3-autodisplay/control"
 	"github.com/lpicanco/i3-autodisplay/display"
+	"github.com/lpicanco/i3-autodisplay/systemd"
 )
 
 func main() {
+	switch flag.Arg(0) {
+	case "":
+		if len(config.Config.XDisplays) > 0 && os.Getenv(supervisedEnv) == "" {
+			superviseDisplays(config.Config.XDisplays)
+		} else {
+			runDaemon()
+		}
+	case "present":
+		present()
+	case "history":
+		printHistory()
+	case "status":
+		printStatus()
+	case "apply":
+		apply(flag.Args()[1:])
+	case "action":
+		action(flag.Args()[1:])
+	case "menu":
+		menu(flag.Args()[1:])
+	case "unpin":
+		unpin()
+	case "watch":
+		watch(flag.Args()[1:])
+	case "cycle":
+		cycle(flag.Args()[1:])
+	case "confirm":
+		if err := control.Call(nil, "confirm"); err != nil {
+			log.Fatalf("error confirming layout: %v", err)
+		}
+	case "list-outputs":
+		display.Connect()
+		listOutputs(flag.Args()[1:])
+	default:
+		log.Fatalf("unknown command: %s", flag.Arg(0))
+	}
+}
+
+func runDaemon() {
+	control.Handle("history", func(args []string) (interface{}, error) {
+		return display.History(), nil
+	})
+	control.Handle("status", func(args []string) (interface{}, error) {
+		return display.CurrentStatus(), nil
+	})
+	control.Handle("apply", func(args []string) (interface{}, error) {
+		var confirm time.Duration
+		if len(args) > 0 {
+			var err error
+			if confirm, err = time.ParseDuration(args[0]); err != nil {
+				return nil, err
+			}
+		}
+
+		profile, pin := "", false
+		if len(args) > 1 {
+			profile = args[1]
+		}
+		if len(args) > 2 {
+			pin = args[2] == "pin"
+		}
+		return display.Apply(profile, pin, confirm)
+	})
+	control.Handle("action", func(args []string) (interface{}, error) {
+		return display.ApplyAction(args)
+	})
+	control.Handle("present", func(args []string) (interface{}, error) {
+		return display.TogglePresentation()
+	})
+	control.Handle("unpin", func(args []string) (interface{}, error) {
+		return display.Unpin()
+	})
+	control.Handle("cycle", func(args []string) (interface{}, error) {
+		step := 1
+		if len(args) > 0 {
+			var err error
+			if step, err = strconv.Atoi(args[0]); err != nil {
+				return nil, err
+			}
+		}
+		return display.CycleProfile(step), nil
+	})
+	control.HandleStream("watch", func(args []string, send func(result interface{}) error) error {
+		changes, stop := display.WatchStatus()
+		defer stop()
+
+		for {
+			if err := send(display.CurrentStatus()); err != nil {
+				return err
+			}
+			<-changes
+		}
+	})
+	control.Handle("confirm", func(args []string) (interface{}, error) {
+		return nil, display.Confirm()
+	})
+	control.Handle("ping", func(args []string) (interface{}, error) {
+		return nil, display.Ping()
+	})
+
+	if err := control.Listen(); err != nil {
+		log.Fatalf("error starting control socket: %v", err)
+	}
+
+	display.Connect()
 	display.Refresh()
+	if err := systemd.Ready(); err != nil {
+		log.Printf("error notifying systemd readiness: %v", err)
+	}
+
 	display.ListenEvents()
 }
+
+func printHistory() {
+	var entries []display.HistoryEntry
+	if err := control.Call(&entries, "history"); err != nil {
+		log.Fatalf("error fetching history: %v", err)
+	}
+
+	for _, entry := range entries {
+		fmt.Printf("%s trigger=%s profile=%s duration=%s outputs=%v\n",
+			entry.Time.Format(time.RFC3339), entry.Trigger, entry.Profile, entry.Duration, entry.Outputs)
+		if entry.Error != "" {
+			fmt.Printf("  failed (rolled back: %t): %s\n", entry.RolledBack, entry.Error)
+		}
+
+		for _, command := range entry.Commands {
+			status := "ok"
+			if command.Error != "" {
+				status = command.Error
+			}
+			fmt.Printf("  %s (%s): %s\n", command.Command, command.Duration, status)
+		}
+	}
+}
+
+func printStatus() {
+	var screens []display.Status
+	if err := control.Call(&screens, "status"); err != nil {
+		log.Fatalf("error fetching status: %v", err)
+	}
+	printScreens(screens)
+}
+
+func printScreens(screens []display.Status) {
+	for _, screen := range screens {
+		fmt.Printf("screen %d: profile=%s pinned=%t updated=%s outputs=%v\n",
+			screen.Screen, screen.Profile, screen.Pinned, screen.Updated.Format(time.RFC3339), screen.Outputs)
+		if screen.Error != "" {
+			fmt.Printf("  failed (rolled back: %t): %s\n", screen.RolledBack, screen.Error)
+		}
+		if power := screen.Power; power != nil {
+			fmt.Printf("  dpms: enabled=%t standby=%s suspend=%s off=%s screen_saver=%s\n",
+				power.Enabled, power.Standby, power.Suspend, power.Off, power.ScreenSaver)
+		}
+	}
+}
+
+// apply asks the daemon to reapply the layout, optionally with the profile
+// given as argument. Flags are accepted before and after the profile.
+func apply(args []string) {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	confirm := flags.Duration("confirm", 0, "Revert the layout unless confirmed within this duration.")
+	pin := flags.Bool("pin", false, "Keep the profile active across hotplug events until unpinned.")
+	flags.Parse(args)
+
+	profile := flags.Arg(0)
+	if flags.NArg() > 1 {
+		flags.Parse(flags.Args()[1:])
+	}
+	if *pin && profile == "" {
+		log.Fatal("a profile is required to pin")
+	}
+
+	request := []string{confirm.String(), profile}
+	if *pin {
+		request = append(request, "pin")
+	}
+
+	var screens []display.Status
+	if err := control.Call(&screens, "apply", request...); err != nil {
+		log.Fatalf("error applying layout: %v", err)
+	}
+	printScreens(screens)
+}
+
+// action applies a quick action, such as "mirror" or "extend left", to the
+// connected outputs.
+func action(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("missing action, expected one of: %s", strings.Join(display.Actions, ", "))
+	}
+
+	var screens []display.Status
+	if err := control.Call(&screens, "action", args...); err != nil {
+		log.Fatalf("error applying action: %v", err)
+	}
+	printScreens(screens)
+}
+
+func present() {
+	var screens []display.Status
+	if err := control.Call(&screens, "present"); err != nil {
+		log.Fatalf("error toggling presentation mode: %v", err)
+	}
+	printScreens(screens)
+}
+
+func unpin() {
+	var screens []display.Status
+	if err := control.Call(&screens, "unpin"); err != nil {
+		log.Fatalf("error unpinning profile: %v", err)
+	}
+	printScreens(screens)
+}
+
+func cycle(args []string) {
+	flags := flag.NewFlagSet("cycle", flag.ExitOnError)
+	reverse := flags.Bool("reverse", false, "Cycle to the previous profile.")
+	flags.Parse(args)
+
+	step := "1"
+	if *reverse {
+		step = "-1"
+	}
+
+	var screens []display.Status
+	if err := control.Call(&screens, "cycle", step); err != nil {
+		log.Fatalf("error cycling profiles: %v", err)
+	}
+	printScreens(screens)
+}
+
+func listOutputs(args []string) {
+	flags := flag.NewFlagSet("list-outputs", flag.ExitOnError)
+	properties := flags.Bool("properties", false, "Show the RandR properties of each output.")
+	flags.Parse(args)
+
+	outputs, err := display.ListOutputs(*properties)
+	if err != nil {
+		log.Fatalf("error listing outputs: %v", err)
+	}
+
+	for _, output := range outputs {
+		status := "disconnected"
+		if output.Connected {
+			status = "connected"
+		}
+		fmt.Printf("%s (screen %d) %s\n", output.Name, output.Screen, status)
+
+		for _, property := range output.Properties {
+			fmt.Printf("  %s: %s (%s", property.Name, property.Value, property.Type)
+			if property.Immutable {
+				fmt.Print(", immutable")
+			}
+			if property.Range && len(property.Values) == 2 {
+				fmt.Printf(", range [%s, %s]", property.Values[0], property.Values[1])
+			} else if len(property.Values) > 0 {
+				fmt.Printf(", values %s", strings.Join(property.Values, ", "))
+			}
+			fmt.Println(")")
+		}
+	}
+}
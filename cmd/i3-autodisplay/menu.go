@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+	"github.com/lpicanco/i3-autodisplay/control"
+	"github.com/lpicanco/i3-autodisplay/display"
+)
+
+type menuEntry struct {
+	label   string
+	command string
+	args    []string
+}
+
+// menu lets the user pick a profile, an output or a quick action through a
+// dmenu compatible launcher, and applies it through the control socket.
+func menu(args []string) {
+	flags := flag.NewFlagSet("menu", flag.ExitOnError)
+	launcher := flags.String("launcher", "dmenu", "Launcher command reading entries on stdin and printing the selected one.")
+	flags.Parse(args)
+
+	var screens []display.Status
+	if err := control.Call(&screens, "status"); err != nil {
+		log.Fatalf("error fetching status: %v", err)
+	}
+
+	entries := getMenuEntries(screens)
+	labels := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		labels = append(labels, entry.label)
+	}
+
+	launcherCommand := exec.Command("sh", "-c", *launcher)
+	launcherCommand.Stdin = strings.NewReader(strings.Join(labels, "\n") + "\n")
+	launcherCommand.Stderr = os.Stderr
+	out, err := launcherCommand.Output()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// dmenu and rofi exit with a non zero status when dismissed.
+		return
+	}
+	if err != nil {
+		log.Fatalf("error running launcher: %v", err)
+	}
+
+	selection := string(bytes.TrimSpace(out))
+	for _, entry := range entries {
+		if entry.label == selection {
+			var result []display.Status
+			if err := control.Call(&result, entry.command, entry.args...); err != nil {
+				log.Fatalf("error applying %s: %v", selection, err)
+			}
+			printScreens(result)
+			return
+		}
+	}
+
+	log.Fatalf("unknown menu entry: %s", selection)
+}
+
+// getMenuEntries lists the profiles matching the connected outputs of any
+// screen, then each connected output on its own, then the quick actions.
+func getMenuEntries(screens []display.Status) []menuEntry {
+	entries := []menuEntry{}
+	seen := make(map[string]bool)
+
+	for _, screen := range screens {
+		for _, profile := range config.MatchingProfiles(screen.Outputs) {
+			if !seen[profile.Name] {
+				seen[profile.Name] = true
+				entries = append(entries, menuEntry{
+					label:   "Profile: " + profile.Name,
+					command: "apply",
+					args:    []string{"0s", profile.Name},
+				})
+			}
+		}
+	}
+
+	outputs := []string{}
+	for _, screen := range screens {
+		for name, connected := range screen.Outputs {
+			if connected {
+				outputs = append(outputs, name)
+			}
+		}
+	}
+	sort.Strings(outputs)
+
+	for _, output := range outputs {
+		entries = append(entries, menuEntry{
+			label:   fmt.Sprintf("Output: %s only", output),
+			command: "action",
+			args:    []string{"only", output},
+		})
+	}
+
+	for _, action := range display.Actions {
+		entries = append(entries, menuEntry{
+			label:   "Action: " + action,
+			command: "action",
+			args:    strings.Fields(action),
+		})
+	}
+
+	return entries
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lpicanco/i3-autodisplay/control"
+	"github.com/lpicanco/i3-autodisplay/systemd"
+)
+
+const (
+	supervisedEnv     = "I3_AUTODISPLAY_SUPERVISED"
+	restartDelay      = 5 * time.Second
+	readinessInterval = time.Second
+)
+
+// superviseDisplays runs one daemon per X display, restarting any that exits.
+// Systemd notifications are handled here, as the service manager only accepts
+// them from the main process: readiness once every daemon applied its initial
+// layout, and watchdog pings while all of them answer.
+func superviseDisplays(displays []string) {
+	for _, display := range displays {
+		go superviseDisplay(display)
+	}
+
+	for pingDisplays(displays, readinessInterval) != nil {
+		time.Sleep(readinessInterval)
+	}
+
+	slog.Info("every display daemon is ready")
+	if err := systemd.Ready(); err != nil {
+		log.Printf("error notifying systemd readiness: %v", err)
+	}
+
+	interval := systemd.WatchdogInterval()
+	if interval == 0 {
+		select {}
+	}
+
+	for range time.Tick(interval) {
+		if err := pingDisplays(displays, interval); err != nil {
+			log.Printf("error checking display daemons: %v", err)
+			continue
+		}
+
+		if err := systemd.Watchdog(); err != nil {
+			log.Printf("error notifying systemd watchdog: %v", err)
+		}
+	}
+}
+
+// pingDisplays checks, through their control sockets, that the daemon of
+// every display runs its event loop and reaches its X server within timeout.
+func pingDisplays(displays []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, display := range displays {
+		if err := control.CallDisplay(display, deadline, nil, "ping"); err != nil {
+			return fmt.Errorf("display %s: %w", display, err)
+		}
+	}
+	return nil
+}
+
+func superviseDisplay(display string) {
+	env := []string{"DISPLAY=" + display, supervisedEnv + "=1"}
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "DISPLAY=") && !strings.HasPrefix(variable, "NOTIFY_SOCKET=") {
+			env = append(env, variable)
+		}
+	}
+
+	for {
+		slog.Info("starting daemon", "display", display)
+
+		cmd := exec.Command(os.Args[0], os.Args[1:]...)
+		cmd.Env = env
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		err := cmd.Run()
+		log.Printf("daemon for display %s exited: %v", display, err)
+		time.Sleep(restartDelay)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/lpicanco/i3-autodisplay/control"
+	"github.com/lpicanco/i3-autodisplay/display"
+)
+
+type waybarOutput struct {
+	Text    string `json:"text"`
+	Alt     string `json:"alt"`
+	Tooltip string `json:"tooltip"`
+	Class   string `json:"class"`
+}
+
+// watch prints the daemon status on every change, in a format suited to a
+// status bar. With i3blocks, clicks read from stdin cycle the profiles.
+func watch(args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	format := flags.String("format", "json", "Output format: i3blocks, json or waybar.")
+	flags.Parse(args)
+
+	var write func(screens []display.Status) error
+	switch *format {
+	case "i3blocks":
+		write = printI3blocks
+		go readClicks(os.Stdin)
+	case "json":
+		write = printJSON
+	case "waybar":
+		write = printWaybar
+	default:
+		log.Fatalf("invalid watch format %q", *format)
+	}
+
+	err := control.Watch(func(data json.RawMessage) error {
+		var screens []display.Status
+		if err := json.Unmarshal(data, &screens); err != nil {
+			return err
+		}
+		return write(screens)
+	}, "watch")
+
+	log.Fatalf("error watching daemon: %v", err)
+}
+
+// readClicks handles the click events i3blocks writes, as JSON lines, to the
+// standard input of persistent blocks.
+func readClicks(stdin io.Reader) {
+	scanner := bufio.NewScanner(stdin)
+	for scanner.Scan() {
+		var click struct {
+			Button int `json:"button"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &click); err != nil {
+			log.Printf("error decoding click event: %v", err)
+			continue
+		}
+
+		step := "1"
+		if click.Button == 3 || click.Button == 5 {
+			step = "-1"
+		}
+		if err := control.Call(nil, "cycle", step); err != nil {
+			log.Printf("error cycling profiles: %v", err)
+		}
+	}
+}
+
+func printI3blocks(screens []display.Status) error {
+	_, err := fmt.Println(summarizeStatus(screens))
+	return err
+}
+
+func printJSON(screens []display.Status) error {
+	data, err := json.Marshal(screens)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Println(string(data))
+	return err
+}
+
+func printWaybar(screens []display.Status) error {
+	output := waybarOutput{Text: summarizeStatus(screens), Class: "ok"}
+
+	tooltip := []string{}
+	for _, screen := range screens {
+		if output.Alt == "" {
+			output.Alt = screen.Profile
+		}
+		tooltip = append(tooltip, fmt.Sprintf("Screen %d: %s", screen.Screen, screen.Profile))
+		if screen.Error != "" {
+			output.Class = "error"
+			tooltip = append(tooltip, screen.Error)
+		}
+	}
+	output.Tooltip = strings.Join(tooltip, "\n")
+
+	data, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Println(string(data))
+	return err
+}
+
+// summarizeStatus formats the profile and connected outputs of each screen,
+// e.g. "docked: DP1 eDP1".
+func summarizeStatus(screens []display.Status) string {
+	summaries := []string{}
+	for _, screen := range screens {
+		outputs := []string{}
+		for name, connected := range screen.Outputs {
+			if connected {
+				outputs = append(outputs, name)
+			}
+		}
+		sort.Strings(outputs)
+
+		summary := fmt.Sprintf("%s: %s", screen.Profile, strings.Join(outputs, " "))
+		if screen.Error != "" {
+			summary += " (error)"
+		}
+		summaries = append(summaries, summary)
+	}
+
+	return strings.Join(summaries, " | ")
+}
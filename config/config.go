@@ -3,8 +3,12 @@ package config
 import (
 	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"path"
+	"strings"
+	"testing"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,15 +16,88 @@ import (
 type Display struct {
 	Name              string
 	RandrExtraOptions string `yaml:"randr_extra_options"`
-	Workspaces        []int
+	Workspaces        []string
+	MirrorOf          string `yaml:"mirror_of"`
+	DPI               float64
+	InputDevices      []string `yaml:"input_devices"`
+	Screen            int
+	Rate              string
+	VRR               string `yaml:"vrr"`
+	Brightness        float64
+	Gamma             []float64
+	ColorTemperature  int `yaml:"color_temperature"`
+	Properties        map[string]string
+	Disabled          bool
+}
+
+type WorkspaceRules struct {
+	Strategy string
+	Pool     []string
+	Outputs  map[int][]string
+}
+
+type WindowRule struct {
+	Class    string
+	Instance string
+	Title    string
+	Mark     string
+	Display  string
+}
+
+type Fallback struct {
+	Policy     string
+	Workspaces string
+}
+
+type DPMS struct {
+	Standby time.Duration
+	Suspend time.Duration
+	Off     time.Duration
+}
+
+type OnUndock struct {
+	Consolidate    bool
+	Lock           string
+	Suspend        bool
+	SuspendCommand string `yaml:"suspend_command"`
+}
+
+type Profile struct {
+	Name         string
+	Outputs      []string
+	Displays     []Display
+	DPI          float64
+	Confirm      bool
+	DPMS         *DPMS `yaml:"dpms"`
+	Presentation bool
 }
 
 var Config = struct {
-	Displays []Display
+	Displays       []Display
+	Profiles       []Profile
+	DPI            float64
+	ManageDPI      bool     `yaml:"manage_dpi"`
+	HistorySize    int      `yaml:"history_size"`
+	XDisplays      []string `yaml:"x_displays"`
+	Fallback       Fallback
+	Workspaces     WorkspaceRules
+	Windows        []WindowRule
+	ConfirmTimeout time.Duration `yaml:"confirm_timeout"`
+	DPMS           *DPMS         `yaml:"dpms"`
+	Notifications  string
+	DisplayKey     string   `yaml:"display_key"`
+	OnUndock       OnUndock `yaml:"on_undock"`
 }{}
 
 func init() {
+	// Tests of the packages reading the configuration run without one.
+	if testing.Testing() {
+		return
+	}
+
 	configFile := getConfirFilePath()
+	setupLogger()
+
 	data, err := os.ReadFile(configFile)
 
 	if err != nil {
@@ -32,6 +109,54 @@ func init() {
 	}
 }
 
+// MatchingProfiles returns the profiles whose outputs are all connected, in
+// configuration order. The first one is used by default. The top level
+// displays are used as a "default" profile when none matches.
+func MatchingProfiles(outputConfiguration map[string]bool) []Profile {
+	profiles := []Profile{}
+	for _, profile := range Config.Profiles {
+		if profileMatches(profile, outputConfiguration) {
+			slog.Debug("profile matched", "profile", profile.Name, "required_outputs", profile.Outputs)
+			if len(profile.Displays) == 0 {
+				profile.Displays = Config.Displays
+			}
+			if profile.DPMS == nil {
+				profile.DPMS = Config.DPMS
+			}
+			profiles = append(profiles, profile)
+		}
+	}
+
+	if len(profiles) == 0 {
+		slog.Debug("no profile matched, using the default one")
+		profiles = append(profiles, Profile{Name: "default", Displays: Config.Displays, DPI: Config.DPI, DPMS: Config.DPMS})
+	}
+	return profiles
+}
+
+func profileMatches(profile Profile, outputConfiguration map[string]bool) bool {
+	for _, output := range profile.Outputs {
+		if !outputConfiguration[output] {
+			slog.Debug("profile skipped", "profile", profile.Name, "missing_output", output)
+			return false
+		}
+	}
+
+	return true
+}
+
+// StateFilePath returns the path of a state file of the daemon managing
+// $DISPLAY, so that the daemons of several displays do not share their state.
+func StateFilePath(name string) string {
+	stateDir := os.Getenv("XDG_STATE_HOME")
+	if stateDir == "" {
+		stateDir = path.Join(os.Getenv("HOME"), ".local", "state")
+	}
+
+	display := strings.NewReplacer(":", "", "/", "_").Replace(os.Getenv("DISPLAY"))
+	return path.Join(stateDir, "i3-autodisplay", display, name)
+}
+
 func getConfirFilePath() (configFile string) {
 	configDir := os.Getenv("XDG_HOME")
 	if configDir == "" {
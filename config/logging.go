@@ -0,0 +1,48 @@
+package config
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+)
+
+var (
+	logLevel  = flag.String("log-level", "info", "Log level: debug, info, warn or error.")
+	logFormat = flag.String("log-format", "text", "Log format: text or json.")
+	systemd   = flag.Bool("systemd", false, "Log without timestamps, for journald.")
+)
+
+// setupLogger installs the structured logger as the default one. Messages
+// written through the standard log package are reported as errors.
+func setupLogger() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalf("invalid log level %q", *logLevel)
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	if *systemd {
+		options.ReplaceAttr = removeTime
+	}
+
+	var handler slog.Handler
+	switch *logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		log.Fatalf("invalid log format %q", *logFormat)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	slog.SetLogLoggerLevel(slog.LevelError)
+}
+
+func removeTime(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Key == slog.TimeKey && len(groups) == 0 {
+		return slog.Attr{}
+	}
+	return attr
+}
@@ -0,0 +1,238 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+type Response struct {
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+type Handler func(args []string) (interface{}, error)
+
+// StreamHandler answers a request with a response per call to send, until it
+// returns or send fails because the client went away.
+type StreamHandler func(args []string, send func(result interface{}) error) error
+
+var (
+	handlers       = make(map[string]Handler)
+	streamHandlers = make(map[string]StreamHandler)
+	handlersMutex  sync.RWMutex
+)
+
+// SocketPath returns the control socket of the daemon managing $DISPLAY.
+func SocketPath() string {
+	return DisplaySocketPath(os.Getenv("DISPLAY"))
+}
+
+// DisplaySocketPath returns the control socket of the daemon managing display.
+func DisplaySocketPath(display string) string {
+	name := "i3-autodisplay"
+	if display != "" {
+		name += "-" + strings.NewReplacer(":", "", "/", "_").Replace(display)
+	}
+
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return path.Join(runtimeDir, name+".sock")
+	}
+
+	return path.Join(os.TempDir(), fmt.Sprintf("%s-%d.sock", name, os.Getuid()))
+}
+
+func Handle(command string, handler Handler) {
+	handlersMutex.Lock()
+	defer handlersMutex.Unlock()
+
+	handlers[command] = handler
+}
+
+func HandleStream(command string, handler StreamHandler) {
+	handlersMutex.Lock()
+	defer handlersMutex.Unlock()
+
+	streamHandlers[command] = handler
+}
+
+// Listen starts serving the control socket in background. Each connection
+// carries a single JSON request answered by a single JSON response.
+func Listen() error {
+	socketPath := SocketPath()
+
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is already in use", socketPath)
+	}
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("control socket listening", "path", socketPath)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Printf("error accepting control connection: %v", err)
+				continue
+			}
+			go serve(conn)
+		}
+	}()
+
+	return nil
+}
+
+func serve(conn net.Conn) {
+	defer conn.Close()
+
+	var request Request
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		log.Printf("error decoding control request: %v", err)
+		return
+	}
+
+	slog.Debug("control request received", "command", request.Command, "args", request.Args)
+
+	handlersMutex.RLock()
+	streamHandler, ok := streamHandlers[request.Command]
+	handlersMutex.RUnlock()
+
+	if ok {
+		stream(conn, streamHandler, request)
+		return
+	}
+
+	if err := json.NewEncoder(conn).Encode(dispatch(request)); err != nil {
+		log.Printf("error encoding control response: %v", err)
+	}
+}
+
+func stream(conn net.Conn, handler StreamHandler, request Request) {
+	encoder := json.NewEncoder(conn)
+	send := func(result interface{}) error {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		return encoder.Encode(Response{Data: data})
+	}
+
+	if err := handler(request.Args, send); err != nil {
+		slog.Debug("control stream closed", "command", request.Command, "error", err)
+		encoder.Encode(Response{Error: err.Error()})
+	}
+}
+
+func dispatch(request Request) Response {
+	handlersMutex.RLock()
+	handler, ok := handlers[request.Command]
+	handlersMutex.RUnlock()
+
+	if !ok {
+		return Response{Error: fmt.Sprintf("unknown command: %s", request.Command)}
+	}
+
+	result, err := handler(request.Args)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+
+	return Response{Data: data}
+}
+
+// Call sends a request to the running daemon and decodes its response data
+// into result.
+func Call(result interface{}, command string, args ...string) error {
+	return call(SocketPath(), time.Time{}, result, command, args...)
+}
+
+// CallDisplay is like Call for the daemon managing display, failing if it
+// does not answer before the deadline.
+func CallDisplay(display string, deadline time.Time, result interface{}, command string, args ...string) error {
+	return call(DisplaySocketPath(display), deadline, result, command, args...)
+}
+
+func call(socketPath string, deadline time.Time, result interface{}, command string, args ...string) error {
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("error connecting to daemon: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(conn).Encode(Request{Command: command, Args: args}); err != nil {
+		return err
+	}
+
+	var response Response
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&response); err != nil {
+		return err
+	}
+
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+
+	if result == nil || response.Data == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Data, result)
+}
+
+// Watch sends a request to the running daemon and calls update with the data
+// of every response it streams back, until the connection is closed or update
+// fails.
+func Watch(update func(data json.RawMessage) error, command string, args ...string) error {
+	conn, err := net.Dial("unix", SocketPath())
+	if err != nil {
+		return fmt.Errorf("error connecting to daemon: %w", err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(Request{Command: command, Args: args}); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var response Response
+		if err := decoder.Decode(&response); err != nil {
+			return err
+		}
+
+		if response.Error != "" {
+			return errors.New(response.Error)
+		}
+
+		if err := update(response.Data); err != nil {
+			return err
+		}
+	}
+}
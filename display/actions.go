@@ -0,0 +1,209 @@
+package display
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const (
+	actionInternalOnly = "internal-only"
+	actionExternalOnly = "external-only"
+	actionExtend       = "extend"
+	actionMirror       = "mirror"
+	actionOnly         = "only"
+	actionCycle        = "cycle"
+
+	connectorTypeAtom  = "ConnectorType"
+	connectorTypePanel = "Panel"
+)
+
+// Actions lists the quick actions working on whatever outputs are connected.
+var Actions = []string{actionMirror, "extend left", "extend right", "extend above", "extend below", actionExternalOnly, actionInternalOnly, actionCycle}
+
+// cycleActions are the quick actions cycled through after the matching
+// profiles, in the order of the usual projector key.
+var cycleActions = [][]string{{actionInternalOnly}, {actionMirror}, {actionExtend, "right"}, {actionExternalOnly}}
+
+var extendOptions = map[string]string{
+	"left":  "--left-of",
+	"right": "--right-of",
+	"above": "--above",
+	"up":    "--above",
+	"below": "--below",
+	"down":  "--below",
+}
+
+var internalOutputPrefixes = []string{"eDP", "LVDS", "DSI"}
+
+// actionOverrides holds the quick action chosen for each screen, used instead
+// of the profiles until the outputs change.
+var actionOverrides = make(map[int][]string)
+
+// ApplyAction lays out the connected outputs of every screen following a quick
+// action, such as "mirror" or "only HDMI1", until the outputs change.
+func ApplyAction(action []string) ([]Status, error) {
+	if len(action) > 0 && action[0] == actionCycle {
+		return CycleLayout(1), nil
+	}
+
+	var err error
+	runAction(func() {
+		applied := false
+		for screen := 0; screen < screenCount(); screen++ {
+			activeScreen = screen
+			var currentOutputConfiguration map[string]bool
+			if currentOutputConfiguration, err = getOutputConfiguration(); err != nil {
+				return
+			}
+			if _, err = getActionProfile(action, currentOutputConfiguration); err != nil {
+				continue
+			}
+
+			clearOverrides()
+			actionOverrides[screen] = action
+			applyLayout("action", currentOutputConfiguration, 0)
+			applied = true
+		}
+
+		if applied {
+			err = nil
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return CurrentStatus(), nil
+}
+
+// getActionProfile builds a profile laying out every output of the active
+// screen according to the action.
+func getActionProfile(action []string, currentOutputConfiguration map[string]bool) (config.Profile, error) {
+	if len(action) == 0 {
+		return config.Profile{}, errors.New("missing action")
+	}
+
+	internal, external := splitInternalOutputs(currentOutputConfiguration)
+	enabled := []string{}
+
+	extendOption := ""
+	switch action[0] {
+	case actionInternalOnly:
+		if internal == "" {
+			return config.Profile{}, errors.New("no internal output connected")
+		}
+		enabled = []string{internal}
+	case actionExternalOnly:
+		if len(external) == 0 {
+			return config.Profile{}, errors.New("no external output connected")
+		}
+		enabled = external
+	case actionExtend, actionMirror:
+		if action[0] == actionExtend {
+			if len(action) < 2 || extendOptions[action[1]] == "" {
+				return config.Profile{}, fmt.Errorf("invalid extend direction: %s", strings.Join(action[1:], " "))
+			}
+			extendOption = extendOptions[action[1]]
+		}
+		if internal != "" {
+			enabled = append(enabled, internal)
+		}
+		enabled = append(enabled, external...)
+	case actionOnly:
+		if len(action) < 2 || !currentOutputConfiguration[action[1]] {
+			return config.Profile{}, fmt.Errorf("output %s not connected", strings.Join(action[1:], " "))
+		}
+		enabled = []string{action[1]}
+	default:
+		return config.Profile{}, fmt.Errorf("unknown action: %s", action[0])
+	}
+
+	if len(enabled) == 0 {
+		return config.Profile{}, errors.New("no output connected")
+	}
+
+	displays := make([]config.Display, 0, len(currentOutputConfiguration))
+	for i, name := range enabled {
+		display := config.Display{Name: name, Screen: activeScreen}
+		switch {
+		case i == 0 && len(enabled) == 1:
+			display.RandrExtraOptions = "--primary --pos 0x0"
+		case i == 0:
+			display.RandrExtraOptions = "--primary"
+		case action[0] == actionMirror:
+			display.MirrorOf = enabled[0]
+		case extendOption != "":
+			display.RandrExtraOptions = extendOption + " " + enabled[i-1]
+		default:
+			display.RandrExtraOptions = "--right-of " + enabled[i-1]
+		}
+		displays = append(displays, display)
+	}
+
+	disabled := []string{}
+	for name := range currentOutputConfiguration {
+		if !contains(enabled, name) {
+			disabled = append(disabled, name)
+		}
+	}
+
+	sort.Strings(disabled)
+	for _, name := range disabled {
+		displays = append(displays, config.Display{Name: name, Screen: activeScreen, Disabled: true})
+	}
+
+	return config.Profile{Name: strings.Join(action, " "), Displays: displays, DPMS: config.Config.DPMS}, nil
+}
+
+// splitInternalOutputs returns the connected laptop panel and the other
+// connected outputs.
+func splitInternalOutputs(currentOutputConfiguration map[string]bool) (string, []string) {
+	internal := ""
+	external := []string{}
+	for name, connected := range currentOutputConfiguration {
+		if !connected {
+			continue
+		}
+
+		if internal == "" && isInternalOutput(name) {
+			internal = name
+		} else {
+			external = append(external, name)
+		}
+	}
+
+	sort.Strings(external)
+	return internal, external
+}
+
+// isInternalOutput recognizes laptop panels by their connector type when the
+// driver reports it, and otherwise by their connector name.
+func isInternalOutput(name string) bool {
+	if output, info, err := findOutput(name); err == nil && info != nil {
+		if atom, ok, err := getOutputPropertyAtom(output, connectorTypeAtom); err == nil && ok {
+			if property, err := getOutputProperty(output, atom); err == nil {
+				return property.Value == connectorTypePanel
+			}
+		}
+	}
+
+	for _, prefix := range internalOutputPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package display
+
+import (
+	"log"
+	"log/slog"
+	"math"
+
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+var backlightAtoms = []string{"Backlight", "BACKLIGHT"}
+
+var (
+	// adjustedColors holds the outputs whose gamma ramp was changed, reset
+	// once no profile configures them anymore.
+	adjustedColors = make(map[string]bool)
+	// savedBacklights holds the backlight of the outputs found before the
+	// first change.
+	savedBacklights = make(map[string]uint32)
+)
+
+// applyColorSettings sets brightness, gamma and color temperature on every
+// active display that configures them, and resets the displays previously
+// adjusted that no longer do. It has to run after each layout change as the
+// gamma ramps are reset when a CRTC gets reassigned.
+func applyColorSettings(displays []config.Display, currentOutputConfiguration map[string]bool) {
+	configured := make(map[string]bool)
+	for _, display := range displays {
+		if !currentOutputConfiguration[display.Name] || !hasColorSettings(display) {
+			continue
+		}
+
+		if len(display.Gamma) != 0 && len(display.Gamma) != 3 {
+			slog.Warn("gamma must have red, green and blue values", "display", display.Name, "gamma", display.Gamma)
+			continue
+		}
+
+		output, info, err := findOutput(display.Name)
+		if err != nil {
+			log.Printf("error setting color on %s: %v", display.Name, err)
+			continue
+		}
+		if info == nil || info.Crtc == 0 {
+			continue
+		}
+
+		brightness := display.Brightness
+		if brightness > 0 && setBacklight(display.Name, output, brightness) {
+			brightness = 0
+		}
+
+		setGamma(display, info.Crtc, brightness)
+		adjustedColors[display.Name] = true
+		configured[display.Name] = true
+	}
+
+	for name := range adjustedColors {
+		if configured[name] || !currentOutputConfiguration[name] {
+			continue
+		}
+
+		output, info, err := findOutput(name)
+		if err != nil {
+			log.Printf("error resetting color on %s: %v", name, err)
+			continue
+		}
+		if info == nil || info.Crtc == 0 {
+			continue
+		}
+
+		resetColor(name, output, info.Crtc)
+		delete(adjustedColors, name)
+	}
+}
+
+// resetColor sets back the backlight found before the first change and an
+// identity gamma ramp.
+func resetColor(name string, output randr.Output, crtc randr.Crtc) {
+	slog.Info("resetting color settings", "display", name)
+	if value, ok := savedBacklights[name]; ok {
+		atom, ok, err := getBacklightAtom(output)
+		if err == nil && ok {
+			err = setIntegerOutputProperty(output, atom, value)
+		}
+		if err != nil {
+			log.Printf("error restoring backlight on %s: %v", name, err)
+		}
+		delete(savedBacklights, name)
+	}
+
+	setGamma(config.Display{Name: name}, crtc, 1)
+}
+
+func hasColorSettings(display config.Display) bool {
+	return display.Brightness > 0 || len(display.Gamma) > 0 || display.ColorTemperature > 0
+}
+
+// setBacklight sets the backlight of internal panels, returning false when
+// the output has no backlight control.
+func setBacklight(name string, output randr.Output, brightness float64) bool {
+	atom, ok, err := getBacklightAtom(output)
+	if err != nil {
+		log.Printf("error setting backlight on %s: %v", name, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	property, err := randr.QueryOutputProperty(xgbConn, output, atom).Reply()
+	if err != nil {
+		log.Printf("error querying randr output property: %v", err)
+		return false
+	}
+
+	if !property.Range || len(property.ValidValues) != 2 {
+		return false
+	}
+
+	if _, ok := savedBacklights[name]; !ok {
+		value, err := getIntegerOutputProperty(output, atom)
+		if err != nil {
+			log.Printf("error getting backlight on %s: %v", name, err)
+			return false
+		}
+		savedBacklights[name] = value
+	}
+
+	low, high := float64(property.ValidValues[0]), float64(property.ValidValues[1])
+	value := uint32(math.Round(low + math.Min(brightness, 1)*(high-low)))
+
+	slog.Info("setting backlight", "display", name, "value", value)
+	if err := setIntegerOutputProperty(output, atom, value); err != nil {
+		log.Printf("error setting backlight on %s: %v", name, err)
+	}
+	return true
+}
+
+func getBacklightAtom(output randr.Output) (xproto.Atom, bool, error) {
+	for _, atomName := range backlightAtoms {
+		atom, ok, err := getOutputPropertyAtom(output, atomName)
+		if err != nil || ok {
+			return atom, ok, err
+		}
+	}
+	return 0, false, nil
+}
+
+func setGamma(display config.Display, crtc randr.Crtc, brightness float64) {
+	if brightness == 0 && len(display.Gamma) == 0 && display.ColorTemperature == 0 {
+		return
+	}
+
+	reply, err := randr.GetCrtcGammaSize(xgbConn, crtc).Reply()
+	if err != nil {
+		log.Printf("error getting randr crtc gamma size: %v", err)
+		return
+	}
+
+	gamma := []float64{1, 1, 1}
+	if len(display.Gamma) == 3 {
+		gamma = display.Gamma
+	}
+
+	whitePoint := []float64{1, 1, 1}
+	if display.ColorTemperature > 0 {
+		whitePoint = colorTemperatureToRGB(float64(display.ColorTemperature))
+	}
+
+	if brightness == 0 {
+		brightness = 1
+	}
+
+	ramps := make([][]uint16, 3)
+	for channel := range ramps {
+		ramps[channel] = gammaRamp(int(reply.Size), gamma[channel], whitePoint[channel]*brightness)
+	}
+
+	slog.Info("setting gamma", "display", display.Name, "gamma", gamma, "white_point", whitePoint, "brightness", brightness)
+	err = randr.SetCrtcGammaChecked(xgbConn, crtc, reply.Size, ramps[0], ramps[1], ramps[2]).Check()
+	if err != nil {
+		log.Printf("error setting gamma on %s: %v", display.Name, err)
+	}
+}
+
+func gammaRamp(size int, gamma, multiplier float64) []uint16 {
+	ramp := make([]uint16, size)
+	if size < 2 || gamma <= 0 {
+		return ramp
+	}
+
+	for i := range ramp {
+		value := math.Pow(float64(i)/float64(size-1), 1/gamma) * math.Min(multiplier, 1)
+		ramp[i] = uint16(math.Round(value * math.MaxUint16))
+	}
+	return ramp
+}
+
+// colorTemperatureToRGB approximates the white point of a black body at the
+// given temperature in Kelvin, scaled so that 6500K is neutral.
+func colorTemperatureToRGB(kelvin float64) []float64 {
+	rgb := blackBody(kelvin)
+	neutral := blackBody(6500)
+
+	for i := range rgb {
+		rgb[i] = math.Min(rgb[i]/neutral[i], 1)
+	}
+	return rgb
+}
+
+func blackBody(kelvin float64) []float64 {
+	temperature := math.Max(1000, math.Min(kelvin, 40000)) / 100
+
+	var red, green, blue float64
+	if temperature <= 66 {
+		red = 255
+		green = 99.4708025861*math.Log(temperature) - 161.1195681661
+	} else {
+		red = 329.698727446 * math.Pow(temperature-60, -0.1332047592)
+		green = 288.1221695283 * math.Pow(temperature-60, -0.0755148492)
+	}
+
+	switch {
+	case temperature >= 66:
+		blue = 255
+	case temperature <= 19:
+		blue = 0
+	default:
+		blue = 138.5177312231*math.Log(temperature-10) - 305.0447927307
+	}
+
+	clamp := func(value float64) float64 {
+		return math.Max(0, math.Min(value, 255)) / 255
+	}
+	return []float64{clamp(red), clamp(green), clamp(blue)}
+}
@@ -0,0 +1,159 @@
+package display
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const defaultConfirmTimeout = 15 * time.Second
+
+type confirmation struct {
+	state  *snapshot
+	timer  *time.Timer
+	prompt *exec.Cmd
+}
+
+var (
+	// actions run on the event loop, which owns the X connection.
+	actions = make(chan func())
+	// listening tells whether the event loop is running, so that layouts can
+	// wait for confirmation and be reverted later.
+	listening     bool
+	confirmations = make(map[int]*confirmation)
+)
+
+// Confirm keeps the layouts waiting for confirmation.
+func Confirm() error {
+	confirmed := false
+	runAction(func() {
+		for screen, pending := range confirmations {
+			pending.cancel()
+			delete(confirmations, screen)
+			confirmed = true
+			slog.Info("layout confirmed", "screen", screen)
+		}
+	})
+
+	if !confirmed {
+		return errors.New("no layout waiting for confirmation")
+	}
+	return nil
+}
+
+func runAction(action func()) {
+	done := make(chan struct{})
+	actions <- func() {
+		defer close(done)
+		action()
+	}
+	<-done
+}
+
+func getConfirmTimeout() time.Duration {
+	if config.Config.ConfirmTimeout > 0 {
+		return config.Config.ConfirmTimeout
+	}
+	return defaultConfirmTimeout
+}
+
+// requestConfirmation shows a prompt and reverts to the given snapshot once
+// the timeout expires.
+func requestConfirmation(state *snapshot, timeout time.Duration) {
+	cancelConfirmation()
+	if !listening {
+		slog.Warn("layout confirmation requires the daemon, skipping", "screen", activeScreen)
+		return
+	}
+
+	pending := &confirmation{state: state, prompt: showConfirmationPrompt(timeout)}
+	pending.timer = time.AfterFunc(timeout, func() {
+		actions <- func() { revertUnconfirmed(pending) }
+	})
+
+	confirmations[activeScreen] = pending
+	slog.Info("waiting for layout confirmation", "screen", activeScreen, "timeout", timeout)
+}
+
+// cancelConfirmation drops the confirmation pending on the active screen, as
+// a newer layout replaced the one it would revert.
+func cancelConfirmation() {
+	if pending := confirmations[activeScreen]; pending != nil {
+		pending.cancel()
+		delete(confirmations, activeScreen)
+		slog.Debug("pending confirmation cancelled", "screen", activeScreen)
+	}
+}
+
+func revertUnconfirmed(pending *confirmation) {
+	screen := pending.state.screen
+	if confirmations[screen] != pending {
+		return
+	}
+
+	delete(confirmations, screen)
+	pending.cancel()
+
+	slog.Warn("layout not confirmed, reverting", "screen", screen)
+	err := errors.New("layout not confirmed")
+	rolledBack := true
+	if restoreErr := pending.state.restore(); restoreErr != nil {
+		log.Printf("error reverting unconfirmed layout: %v", restoreErr)
+		err = fmt.Errorf("%v; revert failed: %v", err, restoreErr)
+		rolledBack = false
+	}
+
+	setStatusError(err, rolledBack)
+
+	currentOutputConfiguration, outputErr := getOutputConfiguration()
+	if outputErr != nil {
+		log.Printf("error getting output configuration: %v", outputErr)
+		return
+	}
+	notifyLayout(getStatusProfile(), currentOutputConfiguration, err, rolledBack)
+}
+
+func (c *confirmation) cancel() {
+	c.timer.Stop()
+	if c.prompt != nil {
+		c.prompt.Process.Kill()
+	}
+}
+
+// showConfirmationPrompt starts an i3-nagbar whose button confirms the layout
+// through the control socket. The button runs this executable with the daemon
+// flags, so that it reads the same configuration file.
+func showConfirmationPrompt(timeout time.Duration) *exec.Cmd {
+	executable, err := os.Executable()
+	if err != nil {
+		log.Printf("error finding i3-autodisplay executable: %v", err)
+		return nil
+	}
+
+	command := []string{shellQuote(executable)}
+	for _, arg := range os.Args[1:] {
+		command = append(command, shellQuote(arg))
+	}
+	command = append(command, "confirm")
+
+	message := fmt.Sprintf("Keep this display layout? It will be reverted in %s.", timeout)
+	prompt := exec.Command("i3-nagbar", "-t", "warning", "-m", message, "-B", "Keep changes", strings.Join(command, " "))
+	if err := prompt.Start(); err != nil {
+		log.Printf("error showing confirmation prompt: %v", err)
+		return nil
+	}
+
+	go prompt.Wait()
+	return prompt
+}
+
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
@@ -0,0 +1,120 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+	"github.com/lpicanco/i3-autodisplay/config"
+	"github.com/lpicanco/i3-autodisplay/i3"
+)
+
+const (
+	initialReconnectDelay = time.Second
+	maxReconnectDelay     = 30 * time.Second
+	// reconnectTimeout bounds the reconnection attempts, so that a daemon
+	// whose session ended exits and releases its control socket for the
+	// daemon of the next session.
+	reconnectTimeout = 5 * time.Minute
+)
+
+// connect dials the X server named by $DISPLAY and initializes RandR on it.
+func connect() error {
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return fmt.Errorf("error initializing xgb: %w", err)
+	}
+
+	if err := randr.Init(conn); err != nil {
+		conn.Close()
+		return fmt.Errorf("error initializing randr: %w", err)
+	}
+	initPowerManagement(conn)
+
+	xgbConn = conn
+	return nil
+}
+
+// subscribeEvents selects the RandR events of every screen, as each root
+// window only reports the changes of its own screen.
+func subscribeEvents() error {
+	for _, screen := range xproto.Setup(xgbConn).Roots {
+		err := randr.SelectInputChecked(xgbConn, screen.Root,
+			randr.NotifyMaskScreenChange|randr.NotifyMaskCrtcChange|randr.NotifyMaskOutputChange).Check()
+
+		if err != nil {
+			return fmt.Errorf("error subscribing to randr events: %w", err)
+		}
+	}
+
+	grabDisplayKey()
+	return nil
+}
+
+// readEvents forwards the events of conn until it is closed. X protocol
+// errors are only logged, as WaitForEvent reports a closed connection by
+// returning neither an event nor an error.
+func readEvents(conn *xgb.Conn, events chan<- xgb.Event, disconnected chan<- struct{}) {
+	for {
+		ev, err := conn.WaitForEvent()
+		if ev == nil && err == nil {
+			disconnected <- struct{}{}
+			return
+		}
+
+		if err != nil {
+			log.Printf("error processing randr event: %v", err)
+			continue
+		}
+		events <- ev
+	}
+}
+
+// reconnect re-establishes the X and i3 connections, retrying with an
+// exponential backoff until both are available, and resets the state tied to
+// the previous X server so the next refresh applies the full layout. The lost
+// connection has already been closed by xgb. It exits once reconnectTimeout
+// has passed, as the X server is then most likely gone for good.
+func reconnect() {
+	deadline := time.Now().Add(reconnectTimeout)
+	delay := initialReconnectDelay
+	for {
+		err := connect()
+		if err == nil {
+			if err = subscribeEvents(); err != nil {
+				xgbConn.Close()
+			}
+		}
+		if err == nil {
+			if err = i3.Ping(); err != nil {
+				xgbConn.Close()
+			}
+		}
+		if err == nil {
+			break
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			log.Fatalf("giving up reconnecting after %s: %v", reconnectTimeout, err)
+		}
+
+		slog.Warn("reconnection failed", "error", err, "retry_in", delay)
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+
+	slog.Info("reconnected to X and i3")
+	lastOutputConfigurations = make(map[int]map[string]bool)
+	lastDisplays = make(map[int][]config.Display)
+	lastInputMatrices = make(map[string]string)
+	userPowerSettings = nil
+	adjustedColors = make(map[string]bool)
+}
@@ -0,0 +1,132 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"strings"
+
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const xftDPIResource = "Xft.dpi:"
+
+func updateDPI(profile config.Profile, displays []config.Display, currentOutputConfiguration map[string]bool) {
+	primary, err := getPrimaryOutput(displays, currentOutputConfiguration)
+	if err != nil {
+		log.Printf("error updating DPI: %v", err)
+		return
+	}
+
+	dpi := profile.DPI
+	overridden := dpi > 0
+	for _, display := range displays {
+		if display.DPI > 0 {
+			overridden = true
+			if dpi == 0 && display.Name == primary {
+				dpi = display.DPI
+			}
+		}
+	}
+
+	if !config.Config.ManageDPI && !overridden {
+		return
+	}
+
+	if dpi == 0 {
+		if dpi, err = getPhysicalDPI(primary); err != nil {
+			log.Printf("error computing DPI: %v", err)
+			return
+		}
+	}
+
+	if dpi <= 0 {
+		slog.Warn("unable to compute DPI", "output", primary)
+		return
+	}
+
+	dpi = math.Round(dpi)
+	slog.Info("setting DPI", "dpi", dpi)
+	setScreenPhysicalSize(dpi)
+	if activeScreen == 0 {
+		setXftDPI(dpi)
+	}
+}
+
+func getPhysicalDPI(outputName string) (float64, error) {
+	info, err := getOutputInfo(outputName)
+	if err != nil || info == nil || info.Crtc == 0 {
+		return 0, err
+	}
+
+	crtc, err := randr.GetCrtcInfo(xgbConn, info.Crtc, 0).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("error getting randr crtc info: %w", err)
+	}
+
+	mmWidth := info.MmWidth
+	if crtc.Rotation&(randr.RotationRotate90|randr.RotationRotate270) != 0 {
+		mmWidth = info.MmHeight
+	}
+
+	if mmWidth == 0 {
+		return 0, nil
+	}
+
+	return float64(crtc.Width) * 25.4 / float64(mmWidth), nil
+}
+
+func setScreenPhysicalSize(dpi float64) {
+	root := rootWindow()
+	geometry, err := xproto.GetGeometry(xgbConn, xproto.Drawable(root)).Reply()
+	if err != nil {
+		log.Printf("error getting root window geometry: %v", err)
+		return
+	}
+
+	mmWidth := uint32(math.Round(float64(geometry.Width) * 25.4 / dpi))
+	mmHeight := uint32(math.Round(float64(geometry.Height) * 25.4 / dpi))
+
+	err = randr.SetScreenSizeChecked(xgbConn, root, geometry.Width, geometry.Height, mmWidth, mmHeight).Check()
+	if err != nil {
+		log.Printf("error setting randr screen size: %v", err)
+	}
+}
+
+func setXftDPI(dpi float64) {
+	root := xproto.Setup(xgbConn).Roots[0].Root
+	reply, err := xproto.GetProperty(xgbConn, false, root, xproto.AtomResourceManager,
+		xproto.AtomString, 0, math.MaxUint32/4).Reply()
+	if err != nil {
+		log.Printf("error reading X resources: %v", err)
+		return
+	}
+
+	resource := fmt.Sprintf("%s\t%.0f", xftDPIResource, dpi)
+	resources := []string{}
+	found := false
+
+	for _, line := range strings.Split(string(reply.Value), "\n") {
+		if strings.HasPrefix(line, xftDPIResource) {
+			line = resource
+			found = true
+		}
+		if line != "" {
+			resources = append(resources, line)
+		}
+	}
+
+	if !found {
+		resources = append(resources, resource)
+	}
+
+	data := []byte(strings.Join(resources, "\n") + "\n")
+	err = xproto.ChangePropertyChecked(xgbConn, xproto.PropModeReplace, root, xproto.AtomResourceManager,
+		xproto.AtomString, 8, uint32(len(data)), data).Check()
+	if err != nil {
+		log.Printf("error updating X resources: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package display
+
+import (
+	"log"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/dpms"
+	"github.com/jezek/xgb/xproto"
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+type PowerStatus struct {
+	Enabled     bool          `json:"enabled"`
+	Standby     time.Duration `json:"standby_ns"`
+	Suspend     time.Duration `json:"suspend_ns"`
+	Off         time.Duration `json:"off_ns"`
+	ScreenSaver time.Duration `json:"screen_saver_ns"`
+}
+
+var dpmsAvailable bool
+
+// initPowerManagement initializes the optional DPMS extension, which servers
+// such as Xvnc may lack.
+func initPowerManagement(conn *xgb.Conn) {
+	dpmsAvailable = false
+	if err := dpms.Init(conn); err != nil {
+		slog.Warn("DPMS extension unavailable", "error", err)
+	} else {
+		dpmsAvailable = true
+	}
+}
+
+// powerSettings are the screen saver and DPMS settings of the X server, as
+// set with "xset s" and "xset dpms".
+type powerSettings struct {
+	timeout        uint16
+	interval       uint16
+	preferBlanking byte
+	allowExposures byte
+	dpmsEnabled    bool
+	standby        uint16
+	suspend        uint16
+	off            uint16
+}
+
+// userPowerSettings holds the settings found before the first change, restored
+// once no profile manages them anymore.
+var userPowerSettings *powerSettings
+
+// applyPowerSettings sets the DPMS timeouts of the profile. Blanking and DPMS
+// are disabled for presentation profiles and in presentation mode. Profiles
+// without DPMS settings get back the settings found before the first change.
+// These are server wide settings, so only the first screen applies them.
+func applyPowerSettings(profile config.Profile) {
+	if activeScreen != 0 || !dpmsAvailable {
+		return
+	}
+
+	presentation := profile.Presentation || isPresentationEnabled()
+	if !presentation && profile.DPMS == nil {
+		if userPowerSettings != nil {
+			slog.Info("restoring screen saver and DPMS settings", "profile", profile.Name)
+			setPowerSettings(*userPowerSettings)
+			userPowerSettings = nil
+		}
+		return
+	}
+
+	if userPowerSettings == nil {
+		settings, err := getPowerSettings()
+		if err != nil {
+			log.Printf("error getting screen saver and DPMS settings: %v", err)
+			return
+		}
+		userPowerSettings = settings
+	}
+
+	settings := *userPowerSettings
+	if presentation {
+		slog.Info("disabling screen blanking", "profile", profile.Name)
+		settings.timeout = 0
+		settings.dpmsEnabled = false
+	} else {
+		settings.standby = dpmsSeconds(profile.DPMS.Standby)
+		settings.suspend = dpmsSeconds(profile.DPMS.Suspend)
+		settings.off = dpmsSeconds(profile.DPMS.Off)
+		settings.dpmsEnabled = true
+		slog.Info("setting DPMS timeouts", "profile", profile.Name, "standby", settings.standby, "suspend", settings.suspend, "off", settings.off)
+	}
+
+	setPowerSettings(settings)
+}
+
+func getPowerSettings() (*powerSettings, error) {
+	saver, err := xproto.GetScreenSaver(xgbConn).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := dpms.Info(xgbConn).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	timeouts, err := dpms.GetTimeouts(xgbConn).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	return &powerSettings{
+		timeout:        saver.Timeout,
+		interval:       saver.Interval,
+		preferBlanking: saver.PreferBlanking,
+		allowExposures: saver.AllowExposures,
+		dpmsEnabled:    info.State,
+		standby:        timeouts.StandbyTimeout,
+		suspend:        timeouts.SuspendTimeout,
+		off:            timeouts.OffTimeout,
+	}, nil
+}
+
+func setPowerSettings(settings powerSettings) {
+	err := xproto.SetScreenSaverChecked(xgbConn, int16(settings.timeout), int16(settings.interval),
+		settings.preferBlanking, settings.allowExposures).Check()
+	if err != nil {
+		log.Printf("error setting screen saver: %v", err)
+	}
+
+	if err := dpms.SetTimeoutsChecked(xgbConn, settings.standby, settings.suspend, settings.off).Check(); err != nil {
+		log.Printf("error setting DPMS timeouts: %v", err)
+	}
+
+	if settings.dpmsEnabled {
+		err = dpms.EnableChecked(xgbConn).Check()
+	} else {
+		err = dpms.DisableChecked(xgbConn).Check()
+	}
+	if err != nil {
+		log.Printf("error toggling DPMS: %v", err)
+	}
+}
+
+// dpmsSeconds converts a timeout to the seconds expected by DPMS, where zero
+// disables the level.
+func dpmsSeconds(timeout time.Duration) uint16 {
+	return uint16(math.Min(timeout.Seconds(), math.MaxUint16))
+}
+
+// getPowerStatus reports the server wide screen saver and DPMS settings. It
+// queries the X server, so it only runs on the event loop.
+func getPowerStatus() *PowerStatus {
+	if !dpmsAvailable {
+		return nil
+	}
+
+	settings, err := getPowerSettings()
+	if err != nil {
+		log.Printf("error getting screen saver and DPMS settings: %v", err)
+		return nil
+	}
+
+	return &PowerStatus{
+		Enabled:     settings.dpmsEnabled,
+		Standby:     time.Duration(settings.standby) * time.Second,
+		Suspend:     time.Duration(settings.suspend) * time.Second,
+		Off:         time.Duration(settings.off) * time.Second,
+		ScreenSaver: time.Duration(settings.timeout) * time.Second,
+	}
+}
@@ -0,0 +1,86 @@
+package display
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+)
+
+const (
+	edidAtom             = "EDID"
+	edidLength           = 128
+	edidDescriptorName   = 0xfc
+	edidDescriptorSerial = 0xff
+)
+
+var edidHeader = []byte{0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+
+// monitorIdentity identifies the monitor plugged into an output, regardless of
+// the connector it uses.
+type monitorIdentity struct {
+	manufacturer string
+	product      uint16
+	name         string
+	serial       string
+}
+
+func (m monitorIdentity) matches(value string) bool {
+	return value != "" && (value == m.name || value == m.serial || value == m.String())
+}
+
+func (m monitorIdentity) String() string {
+	return fmt.Sprintf("%s-%04X-%s", m.manufacturer, m.product, m.serial)
+}
+
+func getMonitorIdentity(output randr.Output) (monitorIdentity, bool, error) {
+	atom, ok, err := getOutputPropertyAtom(output, edidAtom)
+	if err != nil || !ok {
+		return monitorIdentity{}, false, err
+	}
+
+	reply, err := randr.GetOutputProperty(xgbConn, output, atom, xproto.AtomAny, 0, edidLength/4, false, false).Reply()
+	if err != nil {
+		return monitorIdentity{}, false, fmt.Errorf("error getting randr output EDID: %w", err)
+	}
+
+	identity, ok := parseEDID(reply.Data)
+	return identity, ok, nil
+}
+
+func parseEDID(data []byte) (monitorIdentity, bool) {
+	if len(data) < edidLength || !bytes.Equal(data[:len(edidHeader)], edidHeader) {
+		return monitorIdentity{}, false
+	}
+
+	// The manufacturer is encoded as three 5-bit letters, "A" being 1.
+	id := uint16(data[8])<<8 | uint16(data[9])
+	identity := monitorIdentity{
+		manufacturer: string([]byte{
+			byte(id>>10&0x1f) + 'A' - 1,
+			byte(id>>5&0x1f) + 'A' - 1,
+			byte(id&0x1f) + 'A' - 1,
+		}),
+		product: uint16(data[11])<<8 | uint16(data[10]),
+		serial:  fmt.Sprintf("%d", uint32(data[15])<<24|uint32(data[14])<<16|uint32(data[13])<<8|uint32(data[12])),
+	}
+
+	for offset := 54; offset+18 <= 126; offset += 18 {
+		descriptor := data[offset : offset+18]
+		if descriptor[0] != 0 || descriptor[1] != 0 {
+			continue
+		}
+
+		text := strings.TrimSpace(strings.SplitN(string(descriptor[5:]), "\n", 2)[0])
+		switch descriptor[3] {
+		case edidDescriptorName:
+			identity.name = text
+		case edidDescriptorSerial:
+			identity.serial = text
+		}
+	}
+
+	return identity, true
+}
@@ -0,0 +1,209 @@
+package display
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+
+	"github.com/jezek/xgb/randr"
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const (
+	fallbackExtendRight = "extend-right"
+	fallbackExtendLeft  = "extend-left"
+	fallbackMirror      = "mirror"
+	fallbackIgnore      = "ignore"
+	fallbackOff         = "off"
+
+	workspacesNextFree    = "next-free"
+	workspacesSplitEvenly = "split-evenly"
+
+	defaultWorkspaceCount = 10
+)
+
+// addFallbackDisplays lays out the connected outputs missing from the profile
+// according to the configured fallback policy. By default they are ignored.
+// With the extend policies, the outputs to place at the edge of the layout are
+// returned, as the edge is only known once the profile is applied.
+func addFallbackDisplays(displays []config.Display, unknown []string, currentOutputConfiguration map[string]bool) ([]config.Display, []string, error) {
+	policy := config.Config.Fallback.Policy
+	if len(unknown) == 0 || policy == "" || policy == fallbackIgnore {
+		return displays, nil, nil
+	}
+
+	extended := []string{}
+	primary := ""
+	if policy == fallbackMirror {
+		var err error
+		if primary, err = getPrimaryOutput(displays, currentOutputConfiguration); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	added := make([]config.Display, 0, len(unknown))
+	for _, name := range unknown {
+		display := config.Display{Name: name}
+
+		switch policy {
+		case fallbackOff:
+			display.Disabled = true
+		case fallbackMirror:
+			if primary != name {
+				display.MirrorOf = primary
+			}
+		case fallbackExtendRight, fallbackExtendLeft:
+			extended = append(extended, name)
+		default:
+			slog.Warn("invalid fallback policy", "policy", policy)
+			return displays, nil, nil
+		}
+
+		added = append(added, display)
+	}
+
+	slog.Info("applying fallback policy", "policy", policy, "outputs", unknown)
+	displays = append(displays, added...)
+	distributeFallbackWorkspaces(displays, len(displays)-len(added), currentOutputConfiguration)
+
+	return displays, extended, nil
+}
+
+// placeExtendedDisplays moves the fallback outputs of the extend policies next
+// to the rightmost or leftmost output of the applied layout. Profiles position
+// their displays relative to each other, so the edge is read from the CRTCs
+// rather than guessed from the display order.
+func placeExtendedDisplays(displays []config.Display, extended []string, currentOutputConfiguration map[string]bool) error {
+	if len(extended) == 0 {
+		return nil
+	}
+
+	left := config.Config.Fallback.Policy == fallbackExtendLeft
+	option := "--right-of"
+	if left {
+		option = "--left-of"
+	}
+
+	edge, err := getLayoutEdge(displays, extended, currentOutputConfiguration, left)
+	if err != nil {
+		return err
+	}
+
+	args := []string{}
+	if screenCount() > 1 {
+		args = append(args, "--screen", strconv.Itoa(activeScreen))
+	}
+	placed := false
+	for _, name := range extended {
+		args = append(args, "--output", name)
+		if edge != "" {
+			args = append(args, option, edge)
+			placed = true
+		}
+		edge = name
+	}
+	if !placed {
+		return nil
+	}
+
+	slog.Info("placing fallback outputs", "screen", activeScreen, "xrandr", args)
+	if out, err := runCommand("xrandr", args...); err != nil {
+		return fmt.Errorf("error executing xrandr: %w\n%s", err, out)
+	}
+	return nil
+}
+
+// getLayoutEdge returns the laid out display reaching furthest to the left or
+// to the right of the screen, leaving out the given outputs.
+func getLayoutEdge(displays []config.Display, excluded []string, currentOutputConfiguration map[string]bool, left bool) (string, error) {
+	edge, edgeX := "", 0
+	for _, display := range displays {
+		if contains(excluded, display.Name) || !isLaidOut(display, currentOutputConfiguration) {
+			continue
+		}
+
+		info, err := getOutputInfo(display.Name)
+		if err != nil {
+			return "", err
+		}
+		if info == nil || info.Crtc == 0 {
+			continue
+		}
+
+		crtc, err := randr.GetCrtcInfo(xgbConn, info.Crtc, 0).Reply()
+		if err != nil {
+			return "", fmt.Errorf("error getting randr crtc info: %w", err)
+		}
+
+		x := int(crtc.X)
+		if !left {
+			x += int(crtc.Width)
+		}
+		if edge == "" || (left && x < edgeX) || (!left && x > edgeX) {
+			edge, edgeX = display.Name, x
+		}
+	}
+	return edge, nil
+}
+
+// distributeFallbackWorkspaces assigns workspaces to the fallback displays,
+// which start at index first. With "split-evenly" the workspaces of every
+// display are redistributed instead.
+func distributeFallbackWorkspaces(displays []config.Display, first int, currentOutputConfiguration map[string]bool) {
+	used := make(map[int]bool)
+	for _, display := range displays[:first] {
+		if isLaidOut(display, currentOutputConfiguration) {
+			workspaces, _ := parseWorkspaces(display.Name, display.Workspaces)
+			for _, workspace := range workspaces {
+				used[workspace] = true
+			}
+		}
+	}
+
+	switch config.Config.Fallback.Workspaces {
+	case "":
+	case workspacesNextFree:
+		next := 1
+		for i := first; i < len(displays); i++ {
+			if !isLaidOut(displays[i], currentOutputConfiguration) {
+				continue
+			}
+			for used[next] {
+				next++
+			}
+			displays[i].Workspaces = []string{strconv.Itoa(next)}
+			used[next] = true
+		}
+	case workspacesSplitEvenly:
+		workspaces := make([]int, 0, len(used))
+		for workspace := range used {
+			workspaces = append(workspaces, workspace)
+		}
+		if len(workspaces) == 0 {
+			workspaces = defaultWorkspacePool()
+		}
+		sort.Ints(workspaces)
+
+		targets := []int{}
+		for i, display := range displays {
+			if isLaidOut(display, currentOutputConfiguration) {
+				targets = append(targets, i)
+			}
+		}
+
+		for n, i := range targets {
+			start := n * len(workspaces) / len(targets)
+			end := (n + 1) * len(workspaces) / len(targets)
+			displays[i].Workspaces = formatWorkspaces(workspaces[start:end])
+		}
+	default:
+		slog.Warn("invalid fallback workspace rule", "workspaces", config.Config.Fallback.Workspaces)
+	}
+}
+
+// isLaidOut tells whether the display gets its own area of the screen, being
+// connected, enabled and not mirroring another one.
+func isLaidOut(display config.Display, currentOutputConfiguration map[string]bool) bool {
+	return currentOutputConfiguration[display.Name] && !display.Disabled && display.MirrorOf == ""
+}
@@ -0,0 +1,108 @@
+package display
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+	"github.com/lpicanco/i3-autodisplay/i3"
+)
+
+const defaultHistorySize = 20
+
+type HistoryEntry struct {
+	Time       time.Time       `json:"time"`
+	Trigger    string          `json:"trigger"`
+	Screen     int             `json:"screen"`
+	Outputs    map[string]bool `json:"outputs"`
+	Profile    string          `json:"profile"`
+	Commands   []CommandResult `json:"commands"`
+	Error      string          `json:"error,omitempty"`
+	RolledBack bool            `json:"rolled_back"`
+	Duration   time.Duration   `json:"duration_ns"`
+}
+
+type CommandResult struct {
+	Command  string        `json:"command"`
+	Output   string        `json:"output,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+var (
+	history      []HistoryEntry
+	currentEntry *HistoryEntry
+	historyMutex sync.Mutex
+)
+
+func init() {
+	i3.CommandListener = func(command string, duration time.Duration, err error) {
+		recordCommand("i3 "+command, nil, duration, err)
+	}
+}
+
+func History() []HistoryEntry {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	entries := make([]HistoryEntry, len(history))
+	copy(entries, history)
+	return entries
+}
+
+func startHistoryEntry(trigger string, outputs map[string]bool) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	currentEntry = &HistoryEntry{Time: time.Now(), Trigger: trigger, Screen: activeScreen, Outputs: outputs}
+}
+
+func setHistoryProfile(profile string) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	if currentEntry != nil {
+		currentEntry.Profile = profile
+	}
+}
+
+func recordCommand(command string, out []byte, duration time.Duration, err error) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	if currentEntry == nil {
+		return
+	}
+
+	result := CommandResult{Command: command, Output: string(out), Duration: duration}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	currentEntry.Commands = append(currentEntry.Commands, result)
+}
+
+func finishHistoryEntry(err error, rolledBack bool) {
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+
+	if currentEntry == nil {
+		return
+	}
+
+	if err != nil {
+		currentEntry.Error = err.Error()
+	}
+	currentEntry.RolledBack = rolledBack
+
+	size := config.Config.HistorySize
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+
+	currentEntry.Duration = time.Since(currentEntry.Time)
+	history = append(history, *currentEntry)
+	if len(history) > size {
+		history = history[len(history)-size:]
+	}
+	currentEntry = nil
+}
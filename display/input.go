@@ -0,0 +1,179 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const coordinateTransformationMatrix = "Coordinate Transformation Matrix"
+
+type matrix [3][3]float64
+
+type inputDevice struct {
+	id   string
+	name string
+}
+
+var (
+	xinputDeviceRegex = regexp.MustCompile(`^[^\w]*(.+?)\s+id=(\d+)\s+\[slave\s+pointer`)
+	lastDisplays      = make(map[int][]config.Display)
+	lastInputMatrices = make(map[string]string)
+)
+
+func (m matrix) multiply(other matrix) matrix {
+	var result matrix
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				result[i][j] += m[i][k] * other[k][j]
+			}
+		}
+	}
+	return result
+}
+
+func (m matrix) args() []string {
+	args := make([]string, 0, 9)
+	for _, row := range m {
+		for _, value := range row {
+			args = append(args, fmt.Sprintf("%g", value))
+		}
+	}
+	return args
+}
+
+// mapInputDevices maps the input devices of every active display onto the
+// geometry of its output. Displays whose geometry did not change since the
+// last mapping are skipped.
+func mapInputDevices(displays []config.Display) {
+	lastDisplays[activeScreen] = displays
+
+	hasDevices := false
+	for _, display := range displays {
+		hasDevices = hasDevices || len(display.InputDevices) > 0
+	}
+	if !hasDevices {
+		return
+	}
+
+	root := rootWindow()
+	screen, err := xproto.GetGeometry(xgbConn, xproto.Drawable(root)).Reply()
+	if err != nil {
+		log.Printf("error getting root window geometry: %v", err)
+		return
+	}
+
+	devices := getInputDevices()
+
+	for _, display := range displays {
+		if len(display.InputDevices) == 0 {
+			continue
+		}
+
+		info, err := getOutputInfo(display.Name)
+		if err != nil {
+			log.Printf("error mapping input devices to %s: %v", display.Name, err)
+			continue
+		}
+		if info == nil || info.Crtc == 0 {
+			delete(lastInputMatrices, display.Name)
+			continue
+		}
+
+		crtc, err := randr.GetCrtcInfo(xgbConn, info.Crtc, 0).Reply()
+		if err != nil {
+			log.Printf("error getting randr crtc info: %v", err)
+			continue
+		}
+
+		args := getTransformationMatrix(crtc, screen.Width, screen.Height).args()
+		key := strings.Join(append(args, display.InputDevices...), " ")
+		if lastInputMatrices[display.Name] == key {
+			continue
+		}
+
+		for _, device := range devices {
+			if matchesInputDevice(display, device) {
+				setTransformationMatrix(device, args)
+			}
+		}
+		lastInputMatrices[display.Name] = key
+	}
+}
+
+func remapInputDevices() {
+	if displays, ok := lastDisplays[activeScreen]; ok {
+		mapInputDevices(displays)
+	}
+}
+
+func getTransformationMatrix(crtc *randr.GetCrtcInfoReply, screenWidth, screenHeight uint16) matrix {
+	width, height := float64(screenWidth), float64(screenHeight)
+
+	translate := matrix{
+		{float64(crtc.Width) / width, 0, float64(crtc.X) / width},
+		{0, float64(crtc.Height) / height, float64(crtc.Y) / height},
+		{0, 0, 1},
+	}
+
+	rotate := matrix{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	switch {
+	case crtc.Rotation&randr.RotationRotate90 != 0:
+		rotate = matrix{{0, -1, 1}, {1, 0, 0}, {0, 0, 1}}
+	case crtc.Rotation&randr.RotationRotate180 != 0:
+		rotate = matrix{{-1, 0, 1}, {0, -1, 1}, {0, 0, 1}}
+	case crtc.Rotation&randr.RotationRotate270 != 0:
+		rotate = matrix{{0, 1, 0}, {-1, 0, 1}, {0, 0, 1}}
+	}
+
+	if crtc.Rotation&randr.RotationReflectX != 0 {
+		rotate = matrix{{-1, 0, 1}, {0, 1, 0}, {0, 0, 1}}.multiply(rotate)
+	}
+	if crtc.Rotation&randr.RotationReflectY != 0 {
+		rotate = matrix{{1, 0, 0}, {0, -1, 1}, {0, 0, 1}}.multiply(rotate)
+	}
+
+	return translate.multiply(rotate)
+}
+
+func matchesInputDevice(display config.Display, device inputDevice) bool {
+	for _, name := range display.InputDevices {
+		if strings.Contains(device.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func getInputDevices() []inputDevice {
+	out, err := runCommand("xinput", "list")
+	if err != nil {
+		log.Printf("error listing input devices: %v", err)
+		return nil
+	}
+
+	devices := []inputDevice{}
+	for _, line := range strings.Split(string(out), "\n") {
+		if match := xinputDeviceRegex.FindStringSubmatch(line); match != nil {
+			devices = append(devices, inputDevice{name: match[1], id: match[2]})
+		}
+	}
+	return devices
+}
+
+func setTransformationMatrix(device inputDevice, matrix []string) {
+	args := append([]string{"set-prop", device.id, coordinateTransformationMatrix}, matrix...)
+
+	slog.Info("mapping input device", "device", device.name, "xinput", args)
+	out, err := runCommand("xinput", args...)
+	if err != nil {
+		log.Printf("error mapping input device %s: %s\n%s", device.name, err, out)
+	}
+}
@@ -0,0 +1,154 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/jezek/xgb/xproto"
+	"github.com/lpicanco/i3-autodisplay/config"
+	"github.com/lpicanco/i3-autodisplay/notify"
+)
+
+// keysyms maps the names accepted for display_key to their keysym. Other keys
+// can be given by their numeric keysym, such as "0x1008ff59".
+var keysyms = map[string]xproto.Keysym{
+	"XF86Display": 0x1008ff59,
+	"XF86Launch1": 0x1008ff41,
+	"XF86Tools":   0x1008ff81,
+	"F7":          0xffc4,
+}
+
+var keyModifiers = map[string]uint16{
+	"Shift":   xproto.ModMaskShift,
+	"Control": xproto.ModMaskControl,
+	"Ctrl":    xproto.ModMaskControl,
+	"Mod1":    xproto.ModMask1,
+	"Alt":     xproto.ModMask1,
+	"Mod4":    xproto.ModMask4,
+	"Super":   xproto.ModMask4,
+}
+
+// ignoredModifiers are the lock modifiers, CapsLock and NumLock, which must
+// not prevent the key from being recognized.
+var ignoredModifiers = []uint16{0, xproto.ModMaskLock, xproto.ModMask2, xproto.ModMaskLock | xproto.ModMask2}
+
+var (
+	displayKeycodes  = make(map[xproto.Keycode]bool)
+	displayModifiers uint16
+)
+
+// grabDisplayKey grabs the configured display key on every root window, so
+// its presses are reported even though i3 has the keyboard focus.
+func grabDisplayKey() {
+	if config.Config.DisplayKey == "" {
+		return
+	}
+
+	modifiers, keysym, err := parseKey(config.Config.DisplayKey)
+	if err != nil {
+		log.Printf("invalid display key %q: %v", config.Config.DisplayKey, err)
+		return
+	}
+
+	keycodes, err := getKeycodes(keysym)
+	if err != nil {
+		log.Printf("error getting keyboard mapping: %v", err)
+		return
+	}
+	if len(keycodes) == 0 {
+		slog.Warn("display key not found on the keyboard", "key", config.Config.DisplayKey)
+		return
+	}
+
+	displayKeycodes = make(map[xproto.Keycode]bool)
+	displayModifiers = modifiers
+	for _, screen := range xproto.Setup(xgbConn).Roots {
+		for _, keycode := range keycodes {
+			for _, ignored := range ignoredModifiers {
+				err := xproto.GrabKeyChecked(xgbConn, true, screen.Root, modifiers|ignored, keycode,
+					xproto.GrabModeAsync, xproto.GrabModeAsync).Check()
+				if err != nil {
+					log.Printf("error grabbing display key, is it bound in i3? %v", err)
+					return
+				}
+			}
+			displayKeycodes[keycode] = true
+		}
+	}
+
+	slog.Debug("display key grabbed", "key", config.Config.DisplayKey, "keycodes", keycodes)
+}
+
+func parseKey(spec string) (uint16, xproto.Keysym, error) {
+	parts := strings.Split(spec, "+")
+
+	var modifiers uint16
+	for _, name := range parts[:len(parts)-1] {
+		modifier, ok := keyModifiers[name]
+		if !ok {
+			return 0, 0, fmt.Errorf("unknown modifier %s", name)
+		}
+		modifiers |= modifier
+	}
+
+	name := parts[len(parts)-1]
+	if keysym, ok := keysyms[name]; ok {
+		return modifiers, keysym, nil
+	}
+
+	keysym, err := strconv.ParseUint(name, 0, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unknown key %s", name)
+	}
+	return modifiers, xproto.Keysym(keysym), nil
+}
+
+func getKeycodes(keysym xproto.Keysym) ([]xproto.Keycode, error) {
+	setup := xproto.Setup(xgbConn)
+	count := byte(setup.MaxKeycode - setup.MinKeycode + 1)
+
+	mapping, err := xproto.GetKeyboardMapping(xgbConn, setup.MinKeycode, count).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	keycodes := []xproto.Keycode{}
+	perKeycode := int(mapping.KeysymsPerKeycode)
+	for i, sym := range mapping.Keysyms {
+		if sym == keysym {
+			keycode := setup.MinKeycode + xproto.Keycode(i/perKeycode)
+			if len(keycodes) == 0 || keycodes[len(keycodes)-1] != keycode {
+				keycodes = append(keycodes, keycode)
+			}
+		}
+	}
+	return keycodes, nil
+}
+
+func isDisplayKey(event xproto.KeyPressEvent) bool {
+	state := event.State &^ (xproto.ModMaskLock | xproto.ModMask2)
+	return displayKeycodes[event.Detail] && state == displayModifiers
+}
+
+// handleDisplayKey cycles through the matching profiles and quick actions and
+// tells which layout was chosen.
+func handleDisplayKey() {
+	slog.Info("display key pressed")
+	cycleScreens(1, true)
+
+	layouts := []string{}
+	for _, screen := range CurrentStatus() {
+		layout := screen.Profile
+		if screen.Error != "" {
+			layout += " (failed)"
+		}
+		layouts = append(layouts, layout)
+	}
+
+	if err := notify.Send("Display layout", strings.Join(layouts, "\n"), notify.UrgencyLow); err != nil {
+		log.Printf("error sending notification: %v", err)
+	}
+}
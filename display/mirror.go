@@ -0,0 +1,272 @@
+package display
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/jezek/xgb/randr"
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const presentationStateFile = "presentation"
+
+type outputMode struct {
+	name      string
+	width     uint16
+	height    uint16
+	rate      float64
+	preferred bool
+}
+
+type modeChoice struct {
+	name      string
+	rate      float64
+	scaleFrom string
+}
+
+func (m outputMode) size() string {
+	return fmt.Sprintf("%dx%d", m.width, m.height)
+}
+
+// TogglePresentation enables or disables presentation mode, then reapplies
+// the layout of every screen.
+func TogglePresentation() ([]Status, error) {
+	var err error
+	runAction(func() {
+		enabled := !isPresentationEnabled()
+
+		stateFile := config.StateFilePath(presentationStateFile)
+		if enabled {
+			if err = os.MkdirAll(path.Dir(stateFile), 0755); err != nil {
+				err = fmt.Errorf("error creating state directory: %w", err)
+				return
+			}
+			if err = os.WriteFile(stateFile, nil, 0644); err != nil {
+				err = fmt.Errorf("error enabling presentation mode: %w", err)
+				return
+			}
+		} else if err = os.Remove(stateFile); err != nil {
+			err = fmt.Errorf("error disabling presentation mode: %w", err)
+			return
+		}
+
+		slog.Info("presentation mode toggled", "enabled", enabled)
+		for screen := 0; screen < screenCount(); screen++ {
+			activeScreen = screen
+			var currentOutputConfiguration map[string]bool
+			if currentOutputConfiguration, err = getOutputConfiguration(); err != nil {
+				return
+			}
+			applyLayout("present", currentOutputConfiguration, 0)
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return CurrentStatus(), nil
+}
+
+func isPresentationEnabled() bool {
+	_, err := os.Stat(config.StateFilePath(presentationStateFile))
+	return err == nil
+}
+
+// getDisplays returns the profile displays adjusted to the current outputs:
+// mirrors whose source is not connected are laid out as regular displays and,
+// in presentation mode, every external output mirrors the primary one.
+// Otherwise, outputs missing from the profile follow the fallback policy, and
+// the ones to extend the layout with are returned as well.
+func getDisplays(profileDisplays []config.Display, currentOutputConfiguration map[string]bool) ([]config.Display, []string, error) {
+	displays := make([]config.Display, 0, len(profileDisplays))
+	configured := make(map[string]bool)
+
+	for _, display := range profileDisplays {
+		if display.Screen != activeScreen {
+			continue
+		}
+		if !currentOutputConfiguration[display.MirrorOf] {
+			display.MirrorOf = ""
+		}
+		displays = append(displays, display)
+		configured[display.Name] = true
+	}
+
+	if !isPresentationEnabled() {
+		return addFallbackDisplays(displays, getUnconfiguredOutputs(configured, currentOutputConfiguration), currentOutputConfiguration)
+	}
+
+	primary, err := getPrimaryOutput(displays, currentOutputConfiguration)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range displays {
+		if displays[i].Name != primary && currentOutputConfiguration[displays[i].Name] {
+			displays[i].MirrorOf = primary
+		}
+	}
+
+	for _, name := range getUnconfiguredOutputs(configured, currentOutputConfiguration) {
+		if name != primary {
+			displays = append(displays, config.Display{Name: name, MirrorOf: primary})
+		}
+	}
+
+	return displays, nil, nil
+}
+
+func getUnconfiguredOutputs(configured map[string]bool, currentOutputConfiguration map[string]bool) []string {
+	outputs := []string{}
+	for name, connected := range currentOutputConfiguration {
+		if connected && !configured[name] {
+			outputs = append(outputs, name)
+		}
+	}
+
+	sort.Strings(outputs)
+	return outputs
+}
+
+func getPrimaryOutput(displays []config.Display, currentOutputConfiguration map[string]bool) (string, error) {
+	root := rootWindow()
+	primary, err := randr.GetOutputPrimary(xgbConn, root).Reply()
+	if err != nil {
+		return "", fmt.Errorf("error getting randr primary output: %w", err)
+	}
+
+	if primary.Output != 0 {
+		info, err := randr.GetOutputInfo(xgbConn, primary.Output, 0).Reply()
+		if err != nil {
+			return "", fmt.Errorf("error getting randr output info: %w", err)
+		}
+		if currentOutputConfiguration[string(info.Name)] {
+			return string(info.Name), nil
+		}
+	}
+
+	for _, display := range displays {
+		if currentOutputConfiguration[display.Name] {
+			return display.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// selectMirrorModes picks, for every mirrored source, the largest mode shared
+// with all of its mirrors. Mirrors lacking that mode keep their preferred mode
+// and are scaled to fit the source.
+func selectMirrorModes(displays []config.Display, currentOutputConfiguration map[string]bool, modes map[string][]outputMode) map[string]modeChoice {
+	choices := make(map[string]modeChoice)
+
+	mirrors := make(map[string][]string)
+	for _, display := range displays {
+		if display.MirrorOf != "" && currentOutputConfiguration[display.Name] {
+			mirrors[display.MirrorOf] = append(mirrors[display.MirrorOf], display.Name)
+		}
+	}
+
+	for source, targets := range mirrors {
+		sourceMode, ok := selectCommonMode(modes[source], targets, modes)
+		if !ok {
+			continue
+		}
+		choices[source] = modeChoice{name: sourceMode.name}
+
+		for _, target := range targets {
+			if mode, found := findModeBySize(modes[target], sourceMode); found {
+				choices[target] = modeChoice{name: mode.name}
+			} else {
+				choices[target] = modeChoice{scaleFrom: sourceMode.size()}
+			}
+		}
+	}
+
+	return choices
+}
+
+func selectCommonMode(sourceModes []outputMode, targets []string, modes map[string][]outputMode) (outputMode, bool) {
+	var best, preferred outputMode
+	found := false
+
+	for _, mode := range sourceModes {
+		if mode.preferred && preferred.name == "" {
+			preferred = mode
+		}
+
+		shared := true
+		for _, target := range targets {
+			if _, ok := findModeBySize(modes[target], mode); !ok {
+				shared = false
+				break
+			}
+		}
+
+		if shared && (!found || int(mode.width)*int(mode.height) > int(best.width)*int(best.height)) {
+			best = mode
+			found = true
+		}
+	}
+
+	if found {
+		return best, true
+	}
+	if preferred.name != "" {
+		return preferred, true
+	}
+	if len(sourceModes) > 0 {
+		return sourceModes[0], true
+	}
+	return outputMode{}, false
+}
+
+func findModeBySize(modes []outputMode, size outputMode) (outputMode, bool) {
+	for _, mode := range modes {
+		if mode.width == size.width && mode.height == size.height {
+			return mode, true
+		}
+	}
+	return outputMode{}, false
+}
+
+func getOutputModes() (map[string][]outputMode, error) {
+	outputModes := make(map[string][]outputMode)
+
+	root := rootWindow()
+	resources, err := randr.GetScreenResources(xgbConn, root).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("error getting randr screen resources: %w", err)
+	}
+
+	modeInfos := make(map[randr.Mode]outputMode)
+	names := resources.Names
+	for _, info := range resources.Modes {
+		modeInfos[randr.Mode(info.Id)] = outputMode{
+			name:   string(names[:info.NameLen]),
+			width:  info.Width,
+			height: info.Height,
+			rate:   refreshRate(info),
+		}
+		names = names[info.NameLen:]
+	}
+
+	for _, output := range resources.Outputs {
+		info, err := randr.GetOutputInfo(xgbConn, output, 0).Reply()
+		if err != nil {
+			return nil, fmt.Errorf("error getting randr output info: %w", err)
+		}
+
+		modes := make([]outputMode, 0, len(info.Modes))
+		for i, id := range info.Modes {
+			mode := modeInfos[id]
+			mode.preferred = i < int(info.NumPreferred)
+			modes = append(modes, mode)
+		}
+		outputModes[string(info.Name)] = modes
+	}
+
+	return outputModes, nil
+}
@@ -0,0 +1,82 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+	"github.com/lpicanco/i3-autodisplay/notify"
+)
+
+const (
+	notificationsOff        = "off"
+	notificationsErrorsOnly = "errors-only"
+	notificationsAll        = "all"
+)
+
+// notifyLayout sends a desktop notification describing the applied layout,
+// or why it failed, according to the notifications setting.
+func notifyLayout(profile string, currentOutputConfiguration map[string]bool, err error, rolledBack bool) {
+	switch config.Config.Notifications {
+	case "", notificationsOff:
+		return
+	case notificationsErrorsOnly:
+		if err == nil {
+			return
+		}
+	case notificationsAll:
+	default:
+		slog.Warn("invalid notifications setting", "notifications", config.Config.Notifications)
+		return
+	}
+
+	summary := fmt.Sprintf("Display profile: %s", profile)
+	urgency := byte(notify.UrgencyLow)
+	if err != nil {
+		summary = fmt.Sprintf("Error applying display profile %s", profile)
+		urgency = notify.UrgencyCritical
+	}
+
+	enabled, disabled := getEnabledOutputs(currentOutputConfiguration)
+	body := []string{}
+	if len(enabled) > 0 {
+		body = append(body, "Enabled: "+strings.Join(enabled, ", "))
+	}
+	if len(disabled) > 0 {
+		body = append(body, "Disabled: "+strings.Join(disabled, ", "))
+	}
+	if err != nil {
+		body = append(body, err.Error())
+		if rolledBack {
+			body = append(body, "The previous layout was restored.")
+		}
+	}
+
+	if err := notify.Send(summary, strings.Join(body, "\n"), urgency); err != nil {
+		log.Printf("error sending notification: %v", err)
+	}
+}
+
+// getEnabledOutputs splits the connected outputs depending on whether they
+// are driven by a CRTC.
+func getEnabledOutputs(currentOutputConfiguration map[string]bool) ([]string, []string) {
+	enabled, disabled := []string{}, []string{}
+	for name, connected := range currentOutputConfiguration {
+		if !connected {
+			continue
+		}
+
+		if info, err := getOutputInfo(name); err == nil && info != nil && info.Crtc != 0 {
+			enabled = append(enabled, name)
+		} else {
+			disabled = append(disabled, name)
+		}
+	}
+
+	sort.Strings(enabled)
+	sort.Strings(disabled)
+	return enabled, disabled
+}
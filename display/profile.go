@@ -0,0 +1,270 @@
+package display
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const pinStateFile = "pinned-profile"
+
+// profileOverrides holds the profile chosen by hand for each screen, used
+// instead of the first matching one until the outputs change.
+var profileOverrides = make(map[int]string)
+
+// selectProfile returns the layout of the quick action or the profile chosen
+// by hand, then the pinned profile, and otherwise the first matching one. The
+// pin is dropped once any output required by the pinned profile is
+// disconnected.
+func selectProfile(currentOutputConfiguration map[string]bool) config.Profile {
+	if action := actionOverrides[activeScreen]; action != nil {
+		profile, err := getActionProfile(action, currentOutputConfiguration)
+		if err == nil {
+			return profile
+		}
+
+		slog.Info("quick action no longer applies", "screen", activeScreen, "action", action, "error", err)
+		delete(actionOverrides, activeScreen)
+	}
+
+	profiles := config.MatchingProfiles(currentOutputConfiguration)
+
+	if name := profileOverrides[activeScreen]; name != "" {
+		if profile, ok := findProfile(profiles, name); ok {
+			return profile
+		}
+
+		slog.Info("profile override no longer matches", "screen", activeScreen, "profile", name)
+		delete(profileOverrides, activeScreen)
+	}
+
+	if name := getPinnedProfile(); name != "" {
+		if profile, ok := findProfile(profiles, name); ok {
+			slog.Debug("using pinned profile", "screen", activeScreen, "profile", name)
+			return profile
+		}
+
+		if pinnedOutputsRemoved(name, currentOutputConfiguration) {
+			slog.Info("pinned profile outputs disconnected, unpinning", "profile", name)
+			if err := setPinnedProfile(""); err != nil {
+				log.Printf("error unpinning profile: %v", err)
+			}
+		}
+	}
+
+	return profiles[0]
+}
+
+func clearOverrides() {
+	delete(profileOverrides, activeScreen)
+	delete(actionOverrides, activeScreen)
+}
+
+func findProfile(profiles []config.Profile, name string) (config.Profile, bool) {
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return config.Profile{}, false
+}
+
+// pinnedOutputsRemoved tells whether a required output of the profile is
+// disconnected. Outputs unknown to the active screen belong to another one
+// and are ignored.
+func pinnedOutputsRemoved(name string, currentOutputConfiguration map[string]bool) bool {
+	for _, profile := range config.Config.Profiles {
+		if profile.Name != name {
+			continue
+		}
+
+		for _, output := range profile.Outputs {
+			if connected, ok := currentOutputConfiguration[output]; ok && !connected {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The pinned profile was removed from the configuration.
+	return true
+}
+
+func getPinnedProfile() string {
+	data, err := os.ReadFile(config.StateFilePath(pinStateFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func setPinnedProfile(name string) error {
+	stateFile := config.StateFilePath(pinStateFile)
+	if name == "" {
+		if err := os.Remove(stateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(path.Dir(stateFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(stateFile, []byte(name+"\n"), 0644)
+}
+
+// Apply reapplies the layout of every screen, reverting it after the given
+// timeout unless confirmed. A zero timeout only asks for confirmation for the
+// profiles requiring it. A profile given by name is used on the screens it
+// matches until the outputs change or, when pinned, until unpinned.
+func Apply(profile string, pin bool, confirm time.Duration) ([]Status, error) {
+	if pin && profile == "" {
+		return nil, errors.New("a profile is required to pin")
+	}
+
+	var err error
+	runAction(func() {
+		if profile != "" {
+			matched := false
+			for screen := 0; screen < screenCount(); screen++ {
+				activeScreen = screen
+				var currentOutputConfiguration map[string]bool
+				if currentOutputConfiguration, err = getOutputConfiguration(); err != nil {
+					return
+				}
+				if _, ok := findProfile(config.MatchingProfiles(currentOutputConfiguration), profile); ok {
+					profileOverrides[screen] = profile
+					delete(actionOverrides, screen)
+					matched = true
+				}
+			}
+
+			if !matched {
+				err = fmt.Errorf("profile %s does not match the connected outputs", profile)
+				return
+			}
+		}
+
+		if pin {
+			if err = setPinnedProfile(profile); err != nil {
+				err = fmt.Errorf("error pinning profile: %w", err)
+				return
+			}
+			slog.Info("profile pinned", "profile", profile)
+		}
+
+		for screen := 0; screen < screenCount(); screen++ {
+			activeScreen = screen
+			var currentOutputConfiguration map[string]bool
+			if currentOutputConfiguration, err = getOutputConfiguration(); err != nil {
+				return
+			}
+			applyLayout("apply", currentOutputConfiguration, confirm)
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return CurrentStatus(), nil
+}
+
+// Unpin drops the pinned profile and reapplies the layout of every screen.
+func Unpin() ([]Status, error) {
+	if getPinnedProfile() == "" {
+		return nil, errors.New("no profile pinned")
+	}
+
+	var err error
+	runAction(func() {
+		if err = setPinnedProfile(""); err != nil {
+			err = fmt.Errorf("error unpinning profile: %w", err)
+			return
+		}
+		slog.Info("profile unpinned")
+
+		for screen := 0; screen < screenCount(); screen++ {
+			activeScreen = screen
+			clearOverrides()
+			var currentOutputConfiguration map[string]bool
+			if currentOutputConfiguration, err = getOutputConfiguration(); err != nil {
+				return
+			}
+			applyLayout("unpin", currentOutputConfiguration, 0)
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return CurrentStatus(), nil
+}
+
+// CycleProfile applies, on every screen, the matching profile following the
+// active one, or preceding it when step is negative.
+func CycleProfile(step int) []Status {
+	return cycle(step, false)
+}
+
+// CycleLayout is like CycleProfile, the quick actions applicable to the
+// connected outputs being cycled through after the profiles.
+func CycleLayout(step int) []Status {
+	return cycle(step, true)
+}
+
+func cycle(step int, withActions bool) []Status {
+	runAction(func() {
+		cycleScreens(step, withActions)
+	})
+
+	return CurrentStatus()
+}
+
+func cycleScreens(step int, withActions bool) {
+	for screen := 0; screen < screenCount(); screen++ {
+		activeScreen = screen
+		currentOutputConfiguration, err := getOutputConfiguration()
+		if err != nil {
+			log.Printf("error getting output configuration: %v", err)
+			return
+		}
+
+		names := []string{}
+		actions := make(map[string][]string)
+		for _, profile := range config.MatchingProfiles(currentOutputConfiguration) {
+			names = append(names, profile.Name)
+		}
+		if withActions {
+			for _, action := range cycleActions {
+				if profile, err := getActionProfile(action, currentOutputConfiguration); err == nil {
+					names = append(names, profile.Name)
+					actions[profile.Name] = action
+				}
+			}
+		}
+
+		next := 0
+		current := getStatusProfile()
+		for i, name := range names {
+			if name == current {
+				next = ((i+step)%len(names) + len(names)) % len(names)
+				break
+			}
+		}
+
+		clearOverrides()
+		if action, ok := actions[names[next]]; ok {
+			actionOverrides[activeScreen] = action
+		} else {
+			profileOverrides[activeScreen] = names[next]
+		}
+		applyLayout("cycle", currentOutputConfiguration, 0)
+	}
+}
@@ -0,0 +1,344 @@
+package display
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const maxPropertyLength = 1024
+
+type OutputProperty struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Value     string   `json:"value"`
+	Range     bool     `json:"range"`
+	Immutable bool     `json:"immutable"`
+	Values    []string `json:"values,omitempty"`
+
+	atom   xproto.Atom
+	typ    xproto.Atom
+	format byte
+}
+
+type OutputInfo struct {
+	Name       string           `json:"name"`
+	Screen     int              `json:"screen"`
+	Connected  bool             `json:"connected"`
+	Properties []OutputProperty `json:"properties,omitempty"`
+}
+
+func ListOutputs(withProperties bool) ([]OutputInfo, error) {
+	outputs := []OutputInfo{}
+
+	for screen := 0; screen < screenCount(); screen++ {
+		activeScreen = screen
+
+		resources, err := randr.GetScreenResources(xgbConn, rootWindow()).Reply()
+		if err != nil {
+			return nil, fmt.Errorf("error getting randr screen resources: %w", err)
+		}
+
+		for _, output := range resources.Outputs {
+			info, err := randr.GetOutputInfo(xgbConn, output, 0).Reply()
+			if err != nil {
+				return nil, fmt.Errorf("error getting randr output info: %w", err)
+			}
+
+			outputInfo := OutputInfo{
+				Name:      string(info.Name),
+				Screen:    screen,
+				Connected: info.Connection == randr.ConnectionConnected,
+			}
+			if withProperties {
+				if outputInfo.Properties, err = getOutputProperties(output); err != nil {
+					return nil, err
+				}
+			}
+			outputs = append(outputs, outputInfo)
+		}
+	}
+
+	return outputs, nil
+}
+
+func getOutputProperties(output randr.Output) ([]OutputProperty, error) {
+	reply, err := randr.ListOutputProperties(xgbConn, output).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("error listing randr output properties: %w", err)
+	}
+
+	properties := make([]OutputProperty, 0, len(reply.Atoms))
+	for _, atom := range reply.Atoms {
+		property, err := getOutputProperty(output, atom)
+		if err != nil {
+			return nil, err
+		}
+		properties = append(properties, property)
+	}
+	return properties, nil
+}
+
+func getOutputProperty(output randr.Output, atom xproto.Atom) (OutputProperty, error) {
+	value, err := randr.GetOutputProperty(xgbConn, output, atom, xproto.AtomAny, 0, maxPropertyLength, false, false).Reply()
+	if err != nil {
+		return OutputProperty{}, fmt.Errorf("error getting randr output property: %w", err)
+	}
+
+	query, err := randr.QueryOutputProperty(xgbConn, output, atom).Reply()
+	if err != nil {
+		return OutputProperty{}, fmt.Errorf("error querying randr output property: %w", err)
+	}
+
+	name, err := getAtomName(atom)
+	if err != nil {
+		return OutputProperty{}, err
+	}
+	typ, err := getAtomName(value.Type)
+	if err != nil {
+		return OutputProperty{}, err
+	}
+
+	property := OutputProperty{
+		Name:      name,
+		Type:      typ,
+		Range:     query.Range,
+		Immutable: query.Immutable,
+		atom:      atom,
+		typ:       value.Type,
+		format:    value.Format,
+	}
+
+	values := []string{}
+	for _, item := range decodePropertyItems(value.Data, value.Format) {
+		values = append(values, property.formatItem(item))
+	}
+	property.Value = strings.Join(values, ",")
+
+	for _, valid := range query.ValidValues {
+		property.Values = append(property.Values, property.formatItem(uint32(valid)))
+	}
+
+	return property, nil
+}
+
+// formatItem formats a property value, atoms by their name unless it cannot
+// be read.
+func (p OutputProperty) formatItem(item uint32) string {
+	if p.typ == xproto.AtomAtom {
+		if name, err := getAtomName(xproto.Atom(item)); err == nil {
+			return name
+		}
+	}
+	if p.typ == xproto.AtomInteger {
+		return strconv.Itoa(int(int32(item)))
+	}
+	return strconv.FormatUint(uint64(item), 10)
+}
+
+// parseItem validates value against the type and allowed values of the
+// property, returning its wire representation.
+func (p OutputProperty) parseItem(value string) (uint32, error) {
+	if p.Immutable {
+		return 0, fmt.Errorf("property %s is immutable", p.Name)
+	}
+
+	var item uint32
+	switch p.typ {
+	case xproto.AtomAtom:
+		atom, ok, err := getAtom(value)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("invalid value %q for property %s, expected one of %v", value, p.Name, p.Values)
+		}
+		item = uint32(atom)
+	case xproto.AtomInteger, xproto.AtomCardinal:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q for property %s: %v", value, p.Name, err)
+		}
+		if p.Range && len(p.Values) == 2 {
+			low, _ := strconv.ParseInt(p.Values[0], 10, 64)
+			high, _ := strconv.ParseInt(p.Values[1], 10, 64)
+			if number < low || number > high {
+				return 0, fmt.Errorf("value %d for property %s is out of range [%d, %d]", number, p.Name, low, high)
+			}
+		}
+		item = uint32(number)
+	default:
+		return 0, fmt.Errorf("unsupported type %s for property %s", p.Type, p.Name)
+	}
+
+	if !p.Range && len(p.Values) > 0 {
+		valid := false
+		for _, allowed := range p.Values {
+			valid = valid || allowed == p.formatItem(item)
+		}
+		if !valid {
+			return 0, fmt.Errorf("invalid value %q for property %s, expected one of %v", value, p.Name, p.Values)
+		}
+	}
+
+	return item, nil
+}
+
+// applyOutputProperties sets the configured properties of every active
+// display. It runs before xrandr so pending values are picked by the mode set.
+func applyOutputProperties(displays []config.Display, currentOutputConfiguration map[string]bool) {
+	for _, display := range displays {
+		if len(display.Properties) == 0 || !currentOutputConfiguration[display.Name] {
+			continue
+		}
+
+		output, info, err := findOutput(display.Name)
+		if err != nil {
+			log.Printf("error setting output properties on %s: %v", display.Name, err)
+			continue
+		}
+		if info == nil {
+			continue
+		}
+
+		names := make([]string, 0, len(display.Properties))
+		for name := range display.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if err := setOutputProperty(output, name, display.Properties[name]); err != nil {
+				slog.Warn("invalid output property", "display", display.Name, "error", err)
+			}
+		}
+	}
+}
+
+func setOutputProperty(output randr.Output, name, value string) error {
+	atom, ok, err := getOutputPropertyAtom(output, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("output does not support property %s", name)
+	}
+
+	property, err := getOutputProperty(output, atom)
+	if err != nil {
+		return err
+	}
+	item, err := property.parseItem(value)
+	if err != nil {
+		return err
+	}
+
+	data := make([]byte, 4)
+	switch property.format {
+	case 8:
+		data = []byte{byte(item)}
+	case 16:
+		data = data[:2]
+		xgb.Put16(data, uint16(item))
+	default:
+		xgb.Put32(data, item)
+	}
+
+	slog.Info("setting output property", "property", name, "value", value)
+	err = randr.ChangeOutputPropertyChecked(xgbConn, output, atom, property.typ, property.format,
+		xproto.PropModeReplace, 1, data).Check()
+	if err != nil {
+		return fmt.Errorf("error setting property %s: %v", name, err)
+	}
+	return nil
+}
+
+func decodePropertyItems(data []byte, format byte) []uint32 {
+	items := []uint32{}
+	switch format {
+	case 8:
+		for _, b := range data {
+			items = append(items, uint32(b))
+		}
+	case 16:
+		for i := 0; i+2 <= len(data); i += 2 {
+			items = append(items, uint32(xgb.Get16(data[i:])))
+		}
+	case 32:
+		for i := 0; i+4 <= len(data); i += 4 {
+			items = append(items, xgb.Get32(data[i:]))
+		}
+	}
+	return items
+}
+
+func getAtom(name string) (xproto.Atom, bool, error) {
+	reply, err := xproto.InternAtom(xgbConn, true, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0, false, fmt.Errorf("error getting atom %s: %w", name, err)
+	}
+
+	return reply.Atom, reply.Atom != xproto.AtomNone, nil
+}
+
+func getAtomName(atom xproto.Atom) (string, error) {
+	reply, err := xproto.GetAtomName(xgbConn, atom).Reply()
+	if err != nil {
+		return "", fmt.Errorf("error getting atom name: %w", err)
+	}
+	return reply.Name, nil
+}
+
+func hasOutputProperty(output randr.Output, property xproto.Atom) (bool, error) {
+	reply, err := randr.ListOutputProperties(xgbConn, output).Reply()
+	if err != nil {
+		return false, fmt.Errorf("error listing randr output properties: %w", err)
+	}
+
+	for _, atom := range reply.Atoms {
+		if atom == property {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getOutputPropertyAtom returns the atom of the named property when the
+// output has it.
+func getOutputPropertyAtom(output randr.Output, name string) (xproto.Atom, bool, error) {
+	atom, ok, err := getAtom(name)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+
+	ok, err = hasOutputProperty(output, atom)
+	return atom, ok, err
+}
+
+func getIntegerOutputProperty(output randr.Output, property xproto.Atom) (uint32, error) {
+	reply, err := randr.GetOutputProperty(xgbConn, output, property, xproto.AtomAny, 0, 1, false, false).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("error getting randr output property: %w", err)
+	}
+
+	if reply.Format != 32 || len(reply.Data) < 4 {
+		return 0, nil
+	}
+	return xgb.Get32(reply.Data), nil
+}
+
+func setIntegerOutputProperty(output randr.Output, property xproto.Atom, value uint32) error {
+	data := make([]byte, 4)
+	xgb.Put32(data, value)
+
+	return randr.ChangeOutputPropertyChecked(xgbConn, output, property, xproto.AtomInteger, 32,
+		xproto.PropModeReplace, 1, data).Check()
+}
@@ -1,10 +1,14 @@
 package display
 
 import (
+	"fmt"
 	"log"
+	"log/slog"
 	"os/exec"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jezek/xgb"
 
@@ -12,97 +16,286 @@ import (
 	"github.com/jezek/xgb/xproto"
 	"github.com/lpicanco/i3-autodisplay/config"
 	"github.com/lpicanco/i3-autodisplay/i3"
+	"github.com/lpicanco/i3-autodisplay/systemd"
 )
 
 var (
-	xgbConn                 *xgb.Conn
-	lastOutputConfiguration map[string]bool
+	xgbConn                  *xgb.Conn
+	lastOutputConfigurations = make(map[int]map[string]bool)
 )
 
-func init() {
-	var err error
-
-	xgbConn, err = xgb.NewConn()
-	if err != nil {
-		log.Fatalf("error initializing xgb: %v", err)
+func Connect() {
+	if err := connect(); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	err = randr.Init(xgbConn)
-	if err != nil {
-		log.Fatalf("error initializing randr: %v", err)
+func Refresh() {
+	refreshAll("startup")
+}
+
+func refreshAll(trigger string) {
+	for screen := 0; screen < screenCount(); screen++ {
+		activeScreen = screen
+		refresh(trigger)
 	}
 }
 
-func Refresh() {
-	currentOutputConfiguration := getOutputConfiguration()
+func refresh(trigger string) {
+	currentOutputConfiguration, err := getOutputConfiguration()
+	if err != nil {
+		log.Printf("error getting output configuration: %v", err)
+		return
+	}
+	lastOutputConfiguration := lastOutputConfigurations[activeScreen]
 
+	slog.Debug("output configuration", "screen", activeScreen, "old", lastOutputConfiguration, "new", currentOutputConfiguration)
 	if reflect.DeepEqual(currentOutputConfiguration, lastOutputConfiguration) {
 		return
 	}
 
+	clearOverrides()
+
+	undocked := isUndocked(lastOutputConfiguration, currentOutputConfiguration)
+	if undocked {
+		prepareUndock()
+	}
+
+	applyLayout(trigger, currentOutputConfiguration, 0)
+
+	if undocked {
+		startHistoryEntry("undock", currentOutputConfiguration)
+		finishUndock(currentOutputConfiguration)
+		finishHistoryEntry(nil, false)
+	}
+}
+
+// applyLayout applies the layout of the active screen, asking for
+// confirmation within the given timeout if it is not zero.
+func applyLayout(trigger string, currentOutputConfiguration map[string]bool, confirm time.Duration) {
+	if !hasConnectedOutput(currentOutputConfiguration) {
+		slog.Warn("no output connected, keeping the current layout", "screen", activeScreen)
+		return
+	}
+
+	startHistoryEntry(trigger, currentOutputConfiguration)
+	profile, rolledBack, err := applyTransaction(currentOutputConfiguration, confirm)
+	finishHistoryEntry(err, rolledBack)
+	updateStatus(profile, currentOutputConfiguration, err, rolledBack)
+	notifyLayout(profile, currentOutputConfiguration, err, rolledBack)
+
+	// Failed layouts are not retried until the outputs change again, as the
+	// rollback itself triggers RandR events.
+	lastOutputConfigurations[activeScreen] = currentOutputConfiguration
+}
+
+// applyTransaction applies the layout, rolling back to the previous RandR
+// state and workspace placement if any step fails. Layouts of profiles
+// requiring confirmation are reverted later unless confirmed.
+func applyTransaction(currentOutputConfiguration map[string]bool, confirm time.Duration) (string, bool, error) {
+	state, err := takeSnapshot()
+	if err != nil {
+		return "", false, fmt.Errorf("error taking snapshot: %w", err)
+	}
+
+	profile, err := applyConfiguration(currentOutputConfiguration)
+	if err == nil {
+		if confirm == 0 && profile.Confirm {
+			confirm = getConfirmTimeout()
+		}
+		if confirm > 0 {
+			requestConfirmation(state, confirm)
+		} else {
+			cancelConfirmation()
+		}
+		return profile.Name, false, nil
+	}
+
+	log.Printf("error applying layout, rolling back: %v", err)
+	if rollbackErr := state.restore(); rollbackErr != nil {
+		return profile.Name, false, fmt.Errorf("%v; rollback failed: %v", err, rollbackErr)
+	}
+
+	slog.Info("layout rolled back", "screen", activeScreen)
+	return profile.Name, true, err
+}
+
+func applyConfiguration(currentOutputConfiguration map[string]bool) (config.Profile, error) {
 	currentWorkspace, err := i3.GetCurrentWorkspaceNumber()
 	if err != nil {
-		log.Fatalf("error getting i3 current workspace: %v", err)
+		return config.Profile{}, fmt.Errorf("error getting i3 current workspace: %w", err)
+	}
+
+	profile := selectProfile(currentOutputConfiguration)
+	setHistoryProfile(profile.Name)
+	displays, extended, err := getDisplays(profile.Displays, currentOutputConfiguration)
+	if err != nil {
+		return profile, err
+	}
+	outputModes, err := getOutputModes()
+	if err != nil {
+		return profile, err
 	}
+	modes := selectMirrorModes(displays, currentOutputConfiguration, outputModes)
+	selectRefreshRates(displays, currentOutputConfiguration, outputModes, modes)
 
 	args := []string{}
-	for _, display := range config.Config.Displays {
+	if screenCount() > 1 {
+		args = append(args, "--screen", strconv.Itoa(activeScreen))
+	}
+	for _, display := range displays {
 		active := currentOutputConfiguration[display.Name]
-		args = append(args, getDisplayOptions(display, active)...)
+		args = append(args, getDisplayOptions(display, active, modes[display.Name])...)
 	}
 
-	log.Println("xrandr", args)
-	cmd := exec.Command("xrandr", args...)
-	out, err := cmd.CombinedOutput()
+	applyOutputProperties(displays, currentOutputConfiguration)
+
+	slog.Info("applying layout", "screen", activeScreen, "profile", profile.Name, "xrandr", args)
+	out, err := runCommand("xrandr", args...)
 
 	if err != nil {
-		log.Fatalf("Error executing xrandr: %s\n%s", err, out)
+		return profile, fmt.Errorf("error executing xrandr: %w\n%s", err, out)
 	}
 
-	for _, display := range config.Config.Displays {
-		if currentOutputConfiguration[display.Name] {
-			refreshDisplay(display)
-		}
+	if err := placeExtendedDisplays(displays, extended, currentOutputConfiguration); err != nil {
+		return profile, err
+	}
+
+	if err := verifyConfiguration(displays, currentOutputConfiguration); err != nil {
+		return profile, fmt.Errorf("error verifying layout: %w", err)
+	}
+
+	workspaces := resolveWorkspaces(displays, currentOutputConfiguration)
+	if err := i3.UpdateWorkspaces(workspaces); err != nil {
+		return profile, fmt.Errorf("error updating i3 workspaces: %w", err)
+	}
+
+	if err := i3.MoveWindows(getWindowRules(displays, currentOutputConfiguration)); err != nil {
+		log.Printf("error moving i3 windows: %v", err)
+	}
+
+	applyVariableRefreshRate(displays, currentOutputConfiguration)
+	applyColorSettings(displays, currentOutputConfiguration)
+	mapInputDevices(displays)
+	updateDPI(profile, displays, currentOutputConfiguration)
+	applyPowerSettings(profile)
+
+	if err := systemd.Status(fmt.Sprintf("Active profile: %s", profile.Name)); err != nil {
+		log.Printf("error notifying systemd status: %v", err)
 	}
 
 	err = i3.SetCurrentWorkspace(currentWorkspace)
 	if err != nil {
-		log.Fatalf("error setting i3 current workspace: %v", err)
+		return profile, fmt.Errorf("error setting i3 current workspace: %w", err)
 	}
 
-	lastOutputConfiguration = currentOutputConfiguration
+	return profile, nil
 }
 
 func ListenEvents() {
-	defer xgbConn.Close()
+	defer func() { xgbConn.Close() }()
 
-	root := xproto.Setup(xgbConn).DefaultScreen(xgbConn).Root
-	err := randr.SelectInputChecked(xgbConn, root,
-		randr.NotifyMaskScreenChange|randr.NotifyMaskCrtcChange|randr.NotifyMaskOutputChange).Check()
+	if err := subscribeEvents(); err != nil {
+		log.Fatal(err)
+	}
 
-	if err != nil {
-		log.Fatalf("error subscribing to randr events: %v", err)
+	events := make(chan xgb.Event)
+	disconnected := make(chan struct{})
+	go readEvents(xgbConn, events, disconnected)
+
+	var watchdog <-chan time.Time
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
 	}
 
+	listening = true
 	for {
-		ev, err := xgbConn.WaitForEvent()
-		if err != nil {
-			log.Fatalf("error processing randr event: %v", err)
+		select {
+		case action := <-actions:
+			action()
+		case ev := <-events:
+			handleEvent(ev)
+		case <-disconnected:
+			slog.Warn("X connection lost, reconnecting")
+			reconnect()
+			go readEvents(xgbConn, events, disconnected)
+			refreshAll("reconnect")
+		case <-watchdog:
+			pingWatchdog()
 		}
+	}
+}
 
-		switch ev.(type) {
-		case randr.ScreenChangeNotifyEvent:
-			Refresh()
+func handleEvent(ev xgb.Event) {
+	eventType := fmt.Sprintf("%T", ev)
+	slog.Debug("randr event received", "type", eventType)
+
+	switch event := ev.(type) {
+	case randr.ScreenChangeNotifyEvent:
+		if selectScreen(event.Root) {
+			refresh(eventType)
+		}
+	case randr.NotifyEvent:
+		if event.SubCode == randr.NotifyCrtcChange && selectScreen(event.U.Cc.Window) {
+			remapInputDevices()
+		}
+	case xproto.KeyPressEvent:
+		if isDisplayKey(event) {
+			handleDisplayKey()
 		}
 	}
 }
 
-func getDisplayOptions(display config.Display, active bool) []string {
-	if active {
-		args := []string{"--output", display.Name, "--auto"}
+// Ping checks that the event loop runs and the X server answers it. It only
+// returns once the initial layout was applied and the event loop started.
+func Ping() error {
+	var err error
+	runAction(func() {
+		err = checkConnection()
+	})
+	return err
+}
+
+// pingWatchdog only notifies systemd after a round trip to the X server, so a
+// hung connection stops the pings and gets the service restarted.
+func pingWatchdog() {
+	if err := checkConnection(); err != nil {
+		log.Printf("error checking X connection: %v", err)
+		return
+	}
+
+	if err := systemd.Watchdog(); err != nil {
+		log.Printf("error notifying systemd watchdog: %v", err)
+	}
+}
+
+func checkConnection() error {
+	_, err := xproto.GetInputFocus(xgbConn).Reply()
+	return err
+}
+
+func getDisplayOptions(display config.Display, active bool, mode modeChoice) []string {
+	if active && !display.Disabled {
+		args := []string{"--output", display.Name}
+		if mode.name != "" {
+			args = append(args, "--mode", mode.name)
+		} else {
+			args = append(args, "--auto")
+		}
+		if mode.rate > 0 {
+			args = append(args, "--rate", fmt.Sprintf("%.2f", mode.rate))
+		}
+		if mode.scaleFrom != "" {
+			args = append(args, "--scale-from", mode.scaleFrom)
+		}
 		if display.RandrExtraOptions != "" {
 			args = append(args, strings.Split(display.RandrExtraOptions, " ")...)
 		}
+		if display.MirrorOf != "" {
+			args = append(args, "--same-as", display.MirrorOf)
+		}
 		return args
 	} else {
 		args := []string{"--output", display.Name, "--off"}
@@ -110,31 +303,64 @@ func getDisplayOptions(display config.Display, active bool) []string {
 	}
 }
 
-func refreshDisplay(display config.Display) {
-	err := i3.UpdateWorkspaces(display)
+func getOutputConfiguration() (map[string]bool, error) {
+	config := make(map[string]bool)
+
+	root := rootWindow()
+	resources, err := randr.GetScreenResources(xgbConn, root).Reply()
+
 	if err != nil {
-		log.Fatalf("Error updating i3 workspaces: %s\n", err)
+		return nil, fmt.Errorf("error getting randr screen resources: %w", err)
+	}
+
+	for _, output := range resources.Outputs {
+		info, err := randr.GetOutputInfo(xgbConn, output, 0).Reply()
+		if err != nil {
+			return nil, fmt.Errorf("error getting randr output info: %w", err)
+		}
+
+		config[string(info.Name)] = info.Connection == randr.ConnectionConnected
 	}
+
+	return config, nil
 }
 
-func getOutputConfiguration() map[string]bool {
-	config := make(map[string]bool)
+func getOutputInfo(name string) (*randr.GetOutputInfoReply, error) {
+	_, info, err := findOutput(name)
+	return info, err
+}
 
-	root := xproto.Setup(xgbConn).DefaultScreen(xgbConn).Root
+// findOutput looks up an output of the active screen by name. The info is nil
+// when there is no such output.
+func findOutput(name string) (randr.Output, *randr.GetOutputInfoReply, error) {
+	root := rootWindow()
 	resources, err := randr.GetScreenResources(xgbConn, root).Reply()
 
 	if err != nil {
-		log.Fatalf("error getting randr screen resources: %v", err)
+		return 0, nil, fmt.Errorf("error getting randr screen resources: %w", err)
 	}
 
 	for _, output := range resources.Outputs {
 		info, err := randr.GetOutputInfo(xgbConn, output, 0).Reply()
 		if err != nil {
-			log.Fatalf("error getting randr output info: %v", err)
+			return 0, nil, fmt.Errorf("error getting randr output info: %w", err)
 		}
 
-		config[string(info.Name)] = info.Connection == randr.ConnectionConnected
+		if string(info.Name) == name {
+			return output, info, nil
+		}
 	}
 
-	return config
+	return 0, nil, nil
+}
+
+func runCommand(name string, args ...string) ([]byte, error) {
+	start := time.Now()
+	out, err := exec.Command(name, args...).CombinedOutput()
+	duration := time.Since(start)
+	slog.Debug("command executed", "command", name, "args", args, "duration", duration, "error", err)
+
+	recordCommand(strings.Join(append([]string{name}, args...), " "), out, duration, err)
+
+	return out, err
 }
@@ -0,0 +1,180 @@
+package display
+
+import (
+	"log"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/jezek/xgb/randr"
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const (
+	maxRate        = "max"
+	vrrOn          = "on"
+	vrrOff         = "off"
+	vrrCapableAtom = "vrr_capable"
+	vrrEnabledAtom = "VRR_ENABLED"
+)
+
+func refreshRate(info randr.ModeInfo) float64 {
+	if info.Htotal == 0 || info.Vtotal == 0 {
+		return 0
+	}
+
+	rate := float64(info.DotClock) / (float64(info.Htotal) * float64(info.Vtotal))
+	if info.ModeFlags&randr.ModeFlagDoubleScan != 0 {
+		rate /= 2
+	}
+	if info.ModeFlags&randr.ModeFlagInterlace != 0 {
+		rate *= 2
+	}
+	return rate
+}
+
+// selectRefreshRates picks the mode matching the configured rate of each
+// display, keeping the resolution already chosen for mirroring or, otherwise,
+// the preferred one.
+func selectRefreshRates(displays []config.Display, currentOutputConfiguration map[string]bool, outputModes map[string][]outputMode, choices map[string]modeChoice) {
+	for _, display := range displays {
+		if display.Rate == "" || !currentOutputConfiguration[display.Name] {
+			continue
+		}
+
+		modes := outputModes[display.Name]
+		choice := choices[display.Name]
+
+		size, ok := preferredMode(modes)
+		if choice.name != "" {
+			size, ok = findModeByName(modes, choice.name)
+		}
+		if !ok {
+			continue
+		}
+
+		mode, ok := selectRate(display, modes, size)
+		if !ok {
+			continue
+		}
+
+		choice.name = mode.name
+		choice.rate = mode.rate
+		choices[display.Name] = choice
+	}
+}
+
+// selectRate picks the mode of the given size with the highest rate for
+// "max", and otherwise the one whose rate is nearest to the configured one.
+func selectRate(display config.Display, modes []outputMode, size outputMode) (outputMode, bool) {
+	highest := display.Rate == maxRate
+	target := 0.0
+	if !highest {
+		rate, err := strconv.ParseFloat(display.Rate, 64)
+		if err != nil {
+			slog.Warn("invalid refresh rate", "display", display.Name, "rate", display.Rate)
+			return outputMode{}, false
+		}
+		target = rate
+	}
+
+	var best outputMode
+	found := false
+	for _, mode := range modes {
+		if mode.width != size.width || mode.height != size.height {
+			continue
+		}
+
+		better := math.Abs(mode.rate-target) < math.Abs(best.rate-target)
+		if highest {
+			better = mode.rate > best.rate
+		}
+		if !found || better {
+			best = mode
+			found = true
+		}
+	}
+
+	return best, found
+}
+
+func preferredMode(modes []outputMode) (outputMode, bool) {
+	for _, mode := range modes {
+		if mode.preferred {
+			return mode, true
+		}
+	}
+
+	if len(modes) > 0 {
+		return modes[0], true
+	}
+	return outputMode{}, false
+}
+
+func findModeByName(modes []outputMode, name string) (outputMode, bool) {
+	for _, mode := range modes {
+		if mode.name == name {
+			return mode, true
+		}
+	}
+	return outputMode{}, false
+}
+
+// applyVariableRefreshRate toggles adaptive sync on the outputs whose driver
+// exposes the VRR_ENABLED property.
+func applyVariableRefreshRate(displays []config.Display, currentOutputConfiguration map[string]bool) {
+	for _, display := range displays {
+		if display.VRR == "" || !currentOutputConfiguration[display.Name] {
+			continue
+		}
+
+		if display.VRR != vrrOn && display.VRR != vrrOff {
+			slog.Warn("invalid vrr setting", "display", display.Name, "vrr", display.VRR)
+			continue
+		}
+
+		output, info, err := findOutput(display.Name)
+		if err != nil {
+			log.Printf("error setting variable refresh rate on %s: %v", display.Name, err)
+			continue
+		}
+		if info == nil {
+			continue
+		}
+
+		enabled, ok, err := getOutputPropertyAtom(output, vrrEnabledAtom)
+		if err != nil {
+			log.Printf("error setting variable refresh rate on %s: %v", display.Name, err)
+			continue
+		}
+		if !ok {
+			slog.Warn("variable refresh rate not supported by driver", "display", display.Name)
+			continue
+		}
+
+		// Drivers without VRR_CAPABLE are assumed to support it.
+		capable, ok, err := getOutputPropertyAtom(output, vrrCapableAtom)
+		capability := uint32(1)
+		if err == nil && ok {
+			capability, err = getIntegerOutputProperty(output, capable)
+		}
+		if err != nil {
+			log.Printf("error setting variable refresh rate on %s: %v", display.Name, err)
+			continue
+		}
+		if capability == 0 {
+			slog.Warn("output is not variable refresh rate capable", "display", display.Name)
+			continue
+		}
+
+		var value uint32
+		if display.VRR == vrrOn {
+			value = 1
+		}
+
+		slog.Info("setting variable refresh rate", "display", display.Name, "vrr", display.VRR)
+		if err := setIntegerOutputProperty(output, enabled, value); err != nil {
+			log.Printf("error setting variable refresh rate on %s: %v", display.Name, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package display
+
+import (
+	"testing"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+func TestSelectRate(t *testing.T) {
+	modes := []outputMode{
+		{name: "1920x1080", width: 1920, height: 1080, rate: 60, preferred: true},
+		{name: "1920x1080", width: 1920, height: 1080, rate: 144},
+		{name: "1920x1080", width: 1920, height: 1080, rate: 120},
+		{name: "1920x1080", width: 1920, height: 1080, rate: 59.94},
+		{name: "1280x720", width: 1280, height: 720, rate: 240},
+	}
+	size := modes[0]
+
+	tests := []struct {
+		rate  string
+		want  float64
+		found bool
+	}{
+		{"max", 144, true},
+		{"120", 120, true},
+		{"59.94", 59.94, true},
+		{"100", 120, true},
+		{"75", 60, true},
+		{"fast", 0, false},
+	}
+
+	for _, test := range tests {
+		mode, found := selectRate(config.Display{Name: "DP1", Rate: test.rate}, modes, size)
+		if found != test.found || mode.rate != test.want {
+			t.Errorf("rate %q: got %v (found %t), want %v (found %t)", test.rate, mode.rate, found, test.want, test.found)
+		}
+		if found && mode.width != size.width {
+			t.Errorf("rate %q: got a %s mode, want %s", test.rate, mode.size(), size.size())
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package display
+
+import (
+	"github.com/jezek/xgb/xproto"
+)
+
+// activeScreen is the X screen whose outputs are being configured. Every
+// RandR query and xrandr invocation targets its root window.
+var activeScreen int
+
+func rootWindow() xproto.Window {
+	return xproto.Setup(xgbConn).Roots[activeScreen].Root
+}
+
+func screenCount() int {
+	return len(xproto.Setup(xgbConn).Roots)
+}
+
+// selectScreen makes the screen owning root the active one.
+func selectScreen(root xproto.Window) bool {
+	for i, screen := range xproto.Setup(xgbConn).Roots {
+		if screen.Root == root {
+			activeScreen = i
+			return true
+		}
+	}
+	return false
+}
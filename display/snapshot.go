@@ -0,0 +1,166 @@
+package display
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/jezek/xgb/randr"
+	"github.com/jezek/xgb/xproto"
+	"github.com/lpicanco/i3-autodisplay/config"
+	"github.com/lpicanco/i3-autodisplay/i3"
+)
+
+type crtcState struct {
+	crtc     randr.Crtc
+	x        int16
+	y        int16
+	mode     randr.Mode
+	rotation uint16
+	outputs  []randr.Output
+}
+
+// snapshot holds the RandR state of the active screen and the workspace
+// placement, so a failed apply can be rolled back.
+type snapshot struct {
+	screen     int
+	width      uint16
+	height     uint16
+	mmWidth    uint32
+	mmHeight   uint32
+	primary    randr.Output
+	crtcs      []crtcState
+	workspaces []i3.WorkspacePlacement
+}
+
+func takeSnapshot() (*snapshot, error) {
+	root := rootWindow()
+	state := &snapshot{screen: activeScreen}
+
+	geometry, err := xproto.GetGeometry(xgbConn, xproto.Drawable(root)).Reply()
+	if err != nil {
+		return nil, err
+	}
+	state.width, state.height = geometry.Width, geometry.Height
+
+	info, err := randr.GetScreenInfo(xgbConn, root).Reply()
+	if err != nil {
+		return nil, err
+	}
+	if int(info.SizeID) < len(info.Sizes) {
+		size := info.Sizes[info.SizeID]
+		state.mmWidth, state.mmHeight = uint32(size.Mwidth), uint32(size.Mheight)
+	}
+
+	primary, err := randr.GetOutputPrimary(xgbConn, root).Reply()
+	if err != nil {
+		return nil, err
+	}
+	state.primary = primary.Output
+
+	resources, err := randr.GetScreenResources(xgbConn, root).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, crtc := range resources.Crtcs {
+		info, err := randr.GetCrtcInfo(xgbConn, crtc, resources.ConfigTimestamp).Reply()
+		if err != nil {
+			return nil, err
+		}
+
+		state.crtcs = append(state.crtcs, crtcState{
+			crtc:     crtc,
+			x:        info.X,
+			y:        info.Y,
+			mode:     info.Mode,
+			rotation: info.Rotation,
+			outputs:  info.Outputs,
+		})
+	}
+
+	state.workspaces, err = i3.GetWorkspacePlacement()
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// restore disables every CRTC, resizes the screen and then re-enables the
+// CRTCs as they were, before putting the workspaces back on their outputs.
+func (s *snapshot) restore() error {
+	activeScreen = s.screen
+	root := rootWindow()
+
+	resources, err := randr.GetScreenResources(xgbConn, root).Reply()
+	if err != nil {
+		return err
+	}
+
+	for _, crtc := range s.crtcs {
+		if err := setCrtcConfig(crtc.crtc, resources.ConfigTimestamp, 0, 0, 0, randr.RotationRotate0, nil); err != nil {
+			return fmt.Errorf("error disabling crtc: %w", err)
+		}
+	}
+
+	err = randr.SetScreenSizeChecked(xgbConn, root, s.width, s.height, s.mmWidth, s.mmHeight).Check()
+	if err != nil {
+		return fmt.Errorf("error restoring screen size: %w", err)
+	}
+
+	for _, crtc := range s.crtcs {
+		if crtc.mode == 0 {
+			continue
+		}
+		err := setCrtcConfig(crtc.crtc, resources.ConfigTimestamp, crtc.x, crtc.y, crtc.mode, crtc.rotation, crtc.outputs)
+		if err != nil {
+			return fmt.Errorf("error restoring crtc: %w", err)
+		}
+	}
+
+	if err := randr.SetOutputPrimaryChecked(xgbConn, root, s.primary).Check(); err != nil {
+		return fmt.Errorf("error restoring primary output: %w", err)
+	}
+
+	return i3.RestoreWorkspacePlacement(s.workspaces)
+}
+
+func setCrtcConfig(crtc randr.Crtc, configTimestamp xproto.Timestamp, x, y int16, mode randr.Mode, rotation uint16, outputs []randr.Output) error {
+	reply, err := randr.SetCrtcConfig(xgbConn, crtc, xproto.TimeCurrentTime, configTimestamp, x, y, mode, rotation, outputs).Reply()
+	if err != nil {
+		return err
+	}
+
+	if reply.Status != randr.SetConfigSuccess {
+		return fmt.Errorf("randr status %d", reply.Status)
+	}
+	return nil
+}
+
+// verifyConfiguration re-reads RandR to check that every laid out or mirrored
+// display got a CRTC and every other one was turned off. Displays the X
+// server does not have are skipped, as xrandr does, since configurations are
+// often shared between machines with different outputs.
+func verifyConfiguration(displays []config.Display, currentOutputConfiguration map[string]bool) error {
+	for _, display := range displays {
+		info, err := getOutputInfo(display.Name)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			slog.Debug("output not found, skipping verification", "display", display.Name)
+			continue
+		}
+
+		enabled := currentOutputConfiguration[display.Name] && !display.Disabled
+		if enabled && info.Crtc == 0 {
+			return fmt.Errorf("output %s was not enabled", display.Name)
+		}
+		if !enabled && info.Crtc != 0 {
+			return fmt.Errorf("output %s was not disabled", display.Name)
+		}
+	}
+
+	slog.Debug("layout verified", "screen", activeScreen)
+	return nil
+}
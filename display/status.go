@@ -0,0 +1,125 @@
+package display
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Status struct {
+	Screen     int             `json:"screen"`
+	Profile    string          `json:"profile"`
+	Pinned     bool            `json:"pinned"`
+	Outputs    map[string]bool `json:"outputs"`
+	Error      string          `json:"error,omitempty"`
+	RolledBack bool            `json:"rolled_back"`
+	Updated    time.Time       `json:"updated"`
+	Power      *PowerStatus    `json:"power,omitempty"`
+}
+
+var (
+	screenStatus    = make(map[int]Status)
+	statusListeners = make(map[chan struct{}]bool)
+	statusMutex     sync.Mutex
+)
+
+// WatchStatus returns a channel signaled after each status change, and a
+// function to stop watching. Changes happening before the previous signal is
+// received are coalesced.
+func WatchStatus() (<-chan struct{}, func()) {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	listener := make(chan struct{}, 1)
+	statusListeners[listener] = true
+
+	return listener, func() {
+		statusMutex.Lock()
+		defer statusMutex.Unlock()
+
+		delete(statusListeners, listener)
+	}
+}
+
+func notifyStatusListeners() {
+	for listener := range statusListeners {
+		select {
+		case listener <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// CurrentStatus returns the outcome of the last layout change of each screen.
+// The first screen also reports the server wide DPMS settings, as they were
+// after that change.
+func CurrentStatus() []Status {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	status := make([]Status, 0, len(screenStatus))
+	for _, screen := range screenStatus {
+		status = append(status, screen)
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Screen < status[j].Screen
+	})
+	return status
+}
+
+func updateStatus(profile string, outputs map[string]bool, err error, rolledBack bool) {
+	power := getScreenPowerStatus()
+
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	status := Status{
+		Screen:     activeScreen,
+		Profile:    profile,
+		Pinned:     profile != "" && profile == getPinnedProfile(),
+		Outputs:    outputs,
+		RolledBack: rolledBack,
+		Updated:    time.Now(),
+		Power:      power,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	screenStatus[activeScreen] = status
+	notifyStatusListeners()
+}
+
+func setStatusError(err error, rolledBack bool) {
+	power := getScreenPowerStatus()
+
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	status := screenStatus[activeScreen]
+	status.Screen = activeScreen
+	status.Error = err.Error()
+	status.RolledBack = rolledBack
+	status.Updated = time.Now()
+	status.Power = power
+	screenStatus[activeScreen] = status
+	notifyStatusListeners()
+}
+
+func getStatusProfile() string {
+	statusMutex.Lock()
+	defer statusMutex.Unlock()
+
+	return screenStatus[activeScreen].Profile
+}
+
+// getScreenPowerStatus queries the power status when updating the first
+// screen, on the event loop, so that status requests do not use the X
+// connection.
+func getScreenPowerStatus() *PowerStatus {
+	if activeScreen != 0 {
+		return nil
+	}
+	return getPowerStatus()
+}
@@ -0,0 +1,114 @@
+package display
+
+import (
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+	"github.com/lpicanco/i3-autodisplay/i3"
+)
+
+const (
+	lidStateGlob          = "/proc/acpi/button/lid/*/state"
+	defaultSuspendCommand = "systemctl suspend"
+)
+
+// isUndocked tells whether an on_undock policy is configured and every
+// external output was disconnected, leaving only the internal one.
+func isUndocked(lastOutputConfiguration, currentOutputConfiguration map[string]bool) bool {
+	if config.Config.OnUndock == (config.OnUndock{}) || lastOutputConfiguration == nil {
+		return false
+	}
+
+	internal, external := splitInternalOutputs(currentOutputConfiguration)
+	if internal == "" || len(external) > 0 {
+		return false
+	}
+
+	_, lastExternal := splitInternalOutputs(lastOutputConfiguration)
+	return len(lastExternal) > 0
+}
+
+// prepareUndock lays out the internal output alone at 0x0 when consolidation
+// is enabled, whatever the matching profile says.
+func prepareUndock() {
+	if config.Config.OnUndock.Consolidate {
+		actionOverrides[activeScreen] = []string{actionInternalOnly}
+	}
+}
+
+// finishUndock moves every workspace to the internal output, then locks the
+// screen and suspends if the lid is closed, as configured.
+func finishUndock(currentOutputConfiguration map[string]bool) {
+	onUndock := config.Config.OnUndock
+	slog.Info("undocked", "screen", activeScreen)
+
+	if onUndock.Consolidate {
+		internal, _ := splitInternalOutputs(currentOutputConfiguration)
+		if err := consolidateWorkspaces(internal); err != nil {
+			log.Printf("error consolidating workspaces: %v", err)
+		}
+	}
+
+	if onUndock.Lock != "" {
+		// Lockers may block until unlocked, so they are not waited for.
+		lock := exec.Command("sh", "-c", onUndock.Lock)
+		if err := lock.Start(); err != nil {
+			log.Printf("error locking screen: %v", err)
+		} else {
+			go lock.Wait()
+		}
+	}
+
+	if onUndock.Suspend && isLidClosed() {
+		command := onUndock.SuspendCommand
+		if command == "" {
+			command = defaultSuspendCommand
+		}
+
+		slog.Info("lid closed, suspending")
+		if out, err := runCommand("sh", "-c", command); err != nil {
+			log.Printf("error suspending: %v\n%s", err, out)
+		}
+	}
+}
+
+func consolidateWorkspaces(output string) error {
+	placement, err := i3.GetWorkspacePlacement()
+	if err != nil {
+		return err
+	}
+
+	for i := range placement {
+		placement[i].Output = output
+	}
+	return i3.RestoreWorkspacePlacement(placement)
+}
+
+func isLidClosed() bool {
+	states, _ := filepath.Glob(lidStateGlob)
+	for _, state := range states {
+		data, err := os.ReadFile(state)
+		if err == nil && strings.Contains(string(data), "closed") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasConnectedOutput tells whether any output is connected. Without one, the
+// current layout is kept rather than turning every output off, and the last
+// applied output configuration is left as is so that undocking is still
+// detected once the internal output comes back.
+func hasConnectedOutput(currentOutputConfiguration map[string]bool) bool {
+	for _, connected := range currentOutputConfiguration {
+		if connected {
+			return true
+		}
+	}
+	return false
+}
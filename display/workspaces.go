@@ -0,0 +1,286 @@
+package display
+
+import (
+	"errors"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+)
+
+const (
+	strategyRoundRobin       = "round-robin"
+	strategyContiguousBlocks = "contiguous-blocks"
+	strategyFillPrimaryFirst = "fill-primary-first"
+
+	workspaceWildcard = "*"
+)
+
+// resolveWorkspaces maps each laid out display to its workspaces, then moves
+// the workspaces having an output preference list to the first matching one.
+func resolveWorkspaces(displays []config.Display, currentOutputConfiguration map[string]bool) map[string][]int {
+	mapping := assignWorkspaces(displays, currentOutputConfiguration)
+	applyOutputPreferences(mapping, displays, currentOutputConfiguration)
+
+	slog.Debug("workspaces resolved", "mapping", mapping)
+	return mapping
+}
+
+// assignWorkspaces keeps explicit numbers and ranges on their display. The
+// remaining workspaces of the pool go to the displays listing the "*" wildcard
+// or, if none does, are spread across every display following the configured
+// strategy.
+func assignWorkspaces(displays []config.Display, currentOutputConfiguration map[string]bool) map[string][]int {
+	mapping := make(map[string][]int)
+	assigned := make(map[int]bool)
+	targets := []string{}
+	wildcards := []string{}
+
+	for _, display := range displays {
+		if !isLaidOut(display, currentOutputConfiguration) {
+			continue
+		}
+
+		workspaces, wildcard := parseWorkspaces(display.Name, display.Workspaces)
+		for _, workspace := range workspaces {
+			if !assigned[workspace] {
+				mapping[display.Name] = append(mapping[display.Name], workspace)
+				assigned[workspace] = true
+			}
+		}
+
+		targets = append(targets, display.Name)
+		if wildcard {
+			wildcards = append(wildcards, display.Name)
+		}
+	}
+
+	pool, _ := parseWorkspaces("pool", config.Config.Workspaces.Pool)
+	if len(pool) == 0 {
+		pool = defaultWorkspacePool()
+	}
+
+	remaining := []int{}
+	for _, workspace := range pool {
+		if !assigned[workspace] {
+			remaining = append(remaining, workspace)
+			assigned[workspace] = true
+		}
+	}
+
+	strategy := config.Config.Workspaces.Strategy
+	if len(wildcards) > 0 {
+		targets = wildcards
+		if strategy == "" {
+			strategy = strategyContiguousBlocks
+		}
+	}
+
+	if len(remaining) == 0 || len(targets) == 0 || strategy == "" {
+		return mapping
+	}
+
+	primary := ""
+	if strategy == strategyFillPrimaryFirst {
+		var err error
+		if primary, err = getPrimaryOutput(displays, currentOutputConfiguration); err != nil {
+			log.Printf("error getting primary output: %v", err)
+		}
+	}
+
+	for output, workspaces := range distributeWorkspaces(strategy, remaining, targets, primary) {
+		mapping[output] = append(mapping[output], workspaces...)
+	}
+
+	return mapping
+}
+
+// applyOutputPreferences moves each workspace with a preference list to the
+// first laid out display matching one of its entries, either by output name
+// or by monitor identity. Workspaces without a match stay where they are.
+func applyOutputPreferences(mapping map[string][]int, displays []config.Display, currentOutputConfiguration map[string]bool) {
+	preferences := config.Config.Workspaces.Outputs
+	if len(preferences) == 0 {
+		return
+	}
+
+	identities := make(map[string]monitorIdentity)
+	outputs := []string{}
+	for _, display := range displays {
+		if !isLaidOut(display, currentOutputConfiguration) {
+			continue
+		}
+
+		outputs = append(outputs, display.Name)
+		output, info, err := findOutput(display.Name)
+		if err != nil || info == nil {
+			continue
+		}
+
+		identity, ok, err := getMonitorIdentity(output)
+		if err != nil {
+			log.Printf("error reading monitor identity of %s: %v", display.Name, err)
+		} else if ok {
+			identities[display.Name] = identity
+		}
+	}
+
+	for workspace, preferred := range preferences {
+		target := ""
+		for _, entry := range preferred {
+			for _, output := range outputs {
+				if entry == output || identities[output].matches(entry) {
+					target = output
+					break
+				}
+			}
+			if target != "" {
+				break
+			}
+		}
+
+		if target == "" {
+			continue
+		}
+
+		for output, workspaces := range mapping {
+			mapping[output] = removeWorkspace(workspaces, workspace)
+		}
+		mapping[target] = append(mapping[target], workspace)
+	}
+}
+
+func removeWorkspace(workspaces []int, workspace int) []int {
+	result := workspaces[:0]
+	for _, w := range workspaces {
+		if w != workspace {
+			result = append(result, w)
+		}
+	}
+	return result
+}
+
+func distributeWorkspaces(strategy string, workspaces []int, targets []string, primary string) map[string][]int {
+	mapping := make(map[string][]int)
+
+	switch strategy {
+	case strategyRoundRobin:
+		for i, workspace := range workspaces {
+			target := targets[i%len(targets)]
+			mapping[target] = append(mapping[target], workspace)
+		}
+	case strategyContiguousBlocks:
+		for n, target := range targets {
+			start := n * len(workspaces) / len(targets)
+			end := (n + 1) * len(workspaces) / len(targets)
+			mapping[target] = append(mapping[target], workspaces[start:end]...)
+		}
+	case strategyFillPrimaryFirst:
+		others := []string{}
+		for _, target := range targets {
+			if target != primary {
+				others = append(others, target)
+			}
+		}
+		if len(others) == len(targets) {
+			primary, others = others[0], others[1:]
+		}
+
+		// Every other display keeps one workspace, the primary gets the rest.
+		// With fewer workspaces than displays, the primary is served first.
+		split := len(workspaces) - len(others)
+		if split < 1 {
+			split = min(1, len(workspaces))
+		}
+		mapping[primary] = workspaces[:split]
+		for i, workspace := range workspaces[split:] {
+			mapping[others[i]] = append(mapping[others[i]], workspace)
+		}
+	default:
+		slog.Warn("invalid workspace strategy", "strategy", strategy)
+	}
+
+	return mapping
+}
+
+// parseWorkspaces expands workspace numbers and ranges such as "1-5". Invalid
+// entries are logged and skipped.
+func parseWorkspaces(owner string, specs []string) ([]int, bool) {
+	workspaces := []int{}
+	wildcard := false
+
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == workspaceWildcard {
+			wildcard = true
+			continue
+		}
+
+		first, last, err := parseWorkspaceRange(spec)
+		if err != nil {
+			slog.Warn("invalid workspace", "display", owner, "workspace", spec, "error", err)
+			continue
+		}
+
+		for workspace := first; workspace <= last; workspace++ {
+			workspaces = append(workspaces, workspace)
+		}
+	}
+
+	return workspaces, wildcard
+}
+
+func parseWorkspaceRange(spec string) (int, int, error) {
+	bounds := strings.SplitN(spec, "-", 2)
+
+	first, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(bounds) == 1 {
+		return first, first, nil
+	}
+
+	last, err := strconv.Atoi(bounds[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if last < first {
+		return 0, 0, errors.New("empty range")
+	}
+	return first, last, nil
+}
+
+func defaultWorkspacePool() []int {
+	pool := make([]int, 0, defaultWorkspaceCount)
+	for workspace := 1; workspace <= defaultWorkspaceCount; workspace++ {
+		pool = append(pool, workspace)
+	}
+	return pool
+}
+
+func formatWorkspaces(workspaces []int) []string {
+	specs := make([]string, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		specs = append(specs, strconv.Itoa(workspace))
+	}
+	return specs
+}
+
+// getWindowRules returns the window rules targeting a laid out display.
+func getWindowRules(displays []config.Display, currentOutputConfiguration map[string]bool) []config.WindowRule {
+	laidOut := make(map[string]bool)
+	for _, display := range displays {
+		laidOut[display.Name] = isLaidOut(display, currentOutputConfiguration)
+	}
+
+	rules := []config.WindowRule{}
+	for _, rule := range config.Config.Windows {
+		if laidOut[rule.Display] {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
@@ -1,10 +1,14 @@
 module github.com/lpicanco/i3-autodisplay
 
-go 1.12
+go 1.22
 
 require (
-	github.com/BurntSushi/xgb v0.0.0-20200324125942-20f126ea2843
-	github.com/jezek/xgb v1.1.1 // indirect
+	github.com/jezek/xgb v1.1.1
 	go.i3wm.org/i3/v4 v4.18.0
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
+
+require (
+	github.com/BurntSushi/xgb v0.0.0-20200324125942-20f126ea2843 // indirect
+	github.com/BurntSushi/xgbutil v0.0.0-20160919175755-f7c97cef3b4e // indirect
+)
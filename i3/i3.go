@@ -3,11 +3,16 @@ package i3
 import (
 	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/lpicanco/i3-autodisplay/config"
 	"go.i3wm.org/i3/v4"
 )
 
+var CommandListener func(command string, duration time.Duration, err error)
+
 func GetCurrentWorkspaceNumber() (int64, error) {
 	ws, err := i3.GetWorkspaces()
 	if err != nil {
@@ -23,22 +28,94 @@ func GetCurrentWorkspaceNumber() (int64, error) {
 	return -1, errors.New("Can't find current workspace")
 }
 
+// Ping checks the IPC connection, reconnecting to i3 if it went away.
+func Ping() error {
+	_, err := i3.GetVersion()
+	return err
+}
+
 func SetCurrentWorkspace(workspaceNum int64) error {
 	command := fmt.Sprintf("workspace %d", workspaceNum)
-	_, err := i3.RunCommand(command)
-	return err
+	return runCommand(command)
+}
+
+type WorkspacePlacement struct {
+	Name    string
+	Output  string
+	Focused bool
 }
 
-func UpdateWorkspaces(display config.Display) error {
-	for _, workspace := range display.Workspaces {
+func GetWorkspacePlacement() ([]WorkspacePlacement, error) {
+	ws, err := i3.GetWorkspaces()
+	if err != nil {
+		return nil, err
+	}
+
+	placement := make([]WorkspacePlacement, 0, len(ws))
+	for _, w := range ws {
+		placement = append(placement, WorkspacePlacement{Name: w.Name, Output: w.Output, Focused: w.Focused})
+	}
 
-		command := fmt.Sprintf("workspace number %d; move workspace to output %s", workspace, display.Name)
-		_, err := i3.RunCommand(command)
+	return placement, nil
+}
 
-		if err != nil {
+// RestoreWorkspacePlacement moves the workspaces back to their outputs and
+// focuses the one that was focused.
+func RestoreWorkspacePlacement(placement []WorkspacePlacement) error {
+	focused := ""
+	for _, workspace := range placement {
+		command := fmt.Sprintf("workspace %s; move workspace to output %s", quote(workspace.Name), workspace.Output)
+		if err := runCommand(command); err != nil {
 			return err
 		}
+
+		if workspace.Focused {
+			focused = workspace.Name
+		}
+	}
+
+	if focused == "" {
+		return nil
+	}
+	return runCommand("workspace " + quote(focused))
+}
+
+func quote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// UpdateWorkspaces moves every workspace to the output it is mapped to.
+func UpdateWorkspaces(workspaces map[string][]int) error {
+	outputs := make([]string, 0, len(workspaces))
+	for output := range workspaces {
+		outputs = append(outputs, output)
+	}
+	sort.Strings(outputs)
+
+	for _, output := range outputs {
+		for _, workspace := range workspaces[output] {
+
+			command := fmt.Sprintf("workspace number %d; move workspace to output %s", workspace, output)
+			err := runCommand(command)
+
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
+
+func runCommand(command string) error {
+	start := time.Now()
+	_, err := i3.RunCommand(command)
+	duration := time.Since(start)
+	slog.Debug("i3 command executed", "command", command, "duration", duration, "error", err)
+
+	if CommandListener != nil {
+		CommandListener(command, duration, err)
+	}
+
+	return err
+}
@@ -0,0 +1,136 @@
+package i3
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+
+	"github.com/lpicanco/i3-autodisplay/config"
+	"go.i3wm.org/i3/v4"
+)
+
+const scratchpadOutput = "__i3"
+
+type windowRule struct {
+	class    *regexp.Regexp
+	instance *regexp.Regexp
+	title    *regexp.Regexp
+	mark     string
+	display  string
+}
+
+// MoveWindows moves the windows matching each rule to its display. Like i3
+// criteria, class, instance and title are regular expressions. Windows not
+// covered by any rule, or in the scratchpad, are left where they are.
+func MoveWindows(rules []config.WindowRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	compiled := []windowRule{}
+	for _, rule := range rules {
+		windowRule, err := compileWindowRule(rule)
+		if err != nil {
+			slog.Warn("invalid window rule", "display", rule.Display, "error", err)
+			continue
+		}
+		compiled = append(compiled, windowRule)
+	}
+
+	tree, err := i3.GetTree()
+	if err != nil {
+		return err
+	}
+
+	return moveWindows(tree.Root, "", compiled)
+}
+
+func moveWindows(node *i3.Node, output string, rules []windowRule) error {
+	if node.Type == i3.OutputNode {
+		output = node.Name
+	}
+
+	if node.Window != 0 && output != scratchpadOutput {
+		for _, rule := range rules {
+			if !rule.matches(node) {
+				continue
+			}
+
+			if rule.display != output {
+				command := fmt.Sprintf("[con_id=%d] move container to output %s", node.ID, rule.display)
+				if err := runCommand(command); err != nil {
+					return err
+				}
+			}
+			break
+		}
+	}
+
+	for _, children := range [][]*i3.Node{node.Nodes, node.FloatingNodes} {
+		for _, child := range children {
+			if err := moveWindows(child, output, rules); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func compileWindowRule(rule config.WindowRule) (windowRule, error) {
+	compiled := windowRule{mark: rule.Mark, display: rule.Display}
+
+	for _, criterion := range []struct {
+		pattern string
+		regexp  **regexp.Regexp
+	}{
+		{rule.Class, &compiled.class},
+		{rule.Instance, &compiled.instance},
+		{rule.Title, &compiled.title},
+	} {
+		if criterion.pattern == "" {
+			continue
+		}
+
+		re, err := regexp.Compile(criterion.pattern)
+		if err != nil {
+			return compiled, err
+		}
+		*criterion.regexp = re
+	}
+
+	if compiled.class == nil && compiled.instance == nil && compiled.title == nil && compiled.mark == "" {
+		return compiled, errors.New("rule has no criteria")
+	}
+
+	return compiled, nil
+}
+
+func (r windowRule) matches(node *i3.Node) bool {
+	properties := node.WindowProperties
+
+	if r.class != nil && !r.class.MatchString(properties.Class) {
+		return false
+	}
+	if r.instance != nil && !r.instance.MatchString(properties.Instance) {
+		return false
+	}
+	if r.title != nil && !r.title.MatchString(node.Name) {
+		return false
+	}
+	if r.mark != "" && !hasMark(node, r.mark) {
+		return false
+	}
+
+	return true
+}
+
+func hasMark(node *i3.Node, mark string) bool {
+	for _, m := range node.Marks {
+		if m == mark {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+const (
+	UrgencyLow      = 0
+	UrgencyNormal   = 1
+	UrgencyCritical = 2
+
+	expireTimeout = 5000
+)
+
+var (
+	// lastID is the id of the last notification, replaced by the next one so
+	// that successive layout changes do not pile up.
+	lastID      uint32
+	lastIDMutex sync.Mutex
+)
+
+// Send shows a desktop notification through org.freedesktop.Notifications on
+// the session bus named by $DBUS_SESSION_BUS_ADDRESS. It relies on gdbus, as
+// shipped with GLib.
+func Send(summary, body string, urgency byte) error {
+	lastIDMutex.Lock()
+	defer lastIDMutex.Unlock()
+
+	out, err := exec.Command("gdbus", "call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		"i3-autodisplay", fmt.Sprint(lastID), "video-display", quote(summary), quote(body), "[]",
+		fmt.Sprintf("{'urgency': <byte %d>}", urgency), fmt.Sprint(expireTimeout)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	// The reply is the notification id, printed as "(uint32 42,)".
+	if _, err := fmt.Sscanf(string(out), "(uint32 %d,)", &lastID); err != nil {
+		return fmt.Errorf("unexpected notification reply %q", out)
+	}
+	return nil
+}
+
+// quote formats a GVariant string literal, as gdbus parses every argument.
+func quote(text string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`).Replace(text) + "'"
+}
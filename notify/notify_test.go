@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeGdbus is a gdbus stand-in recording its arguments, one per line, and
+// printing the reply given in $GDBUS_REPLY.
+const fakeGdbus = `#!/bin/sh
+printf '%s\n' "$@" > "$GDBUS_ARGS"
+echo "$GDBUS_REPLY"
+[ -z "$GDBUS_FAIL" ]
+`
+
+// installGdbus puts the stand-in first on PATH and returns the file holding
+// the arguments of its last call.
+func installGdbus(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "gdbus"), []byte(fakeGdbus), 0755); err != nil {
+		t.Fatalf("error writing gdbus stand-in: %v", err)
+	}
+
+	args := filepath.Join(dir, "args")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("GDBUS_ARGS", args)
+	t.Setenv("GDBUS_FAIL", "")
+
+	lastID = 0
+	return args
+}
+
+func readArgs(t *testing.T, path string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading gdbus arguments: %v", err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func TestSend(t *testing.T) {
+	args := installGdbus(t)
+	t.Setenv("GDBUS_REPLY", "(uint32 42,)")
+
+	if err := Send("Display layout", "home\nHDMI1 enabled", UrgencyCritical); err != nil {
+		t.Fatalf("error sending notification: %v", err)
+	}
+
+	want := []string{
+		"call", "--session",
+		"--dest", "org.freedesktop.Notifications",
+		"--object-path", "/org/freedesktop/Notifications",
+		"--method", "org.freedesktop.Notifications.Notify",
+		"i3-autodisplay", "0", "video-display", "'Display layout'", `'home\nHDMI1 enabled'`, "[]",
+		"{'urgency': <byte 2>}", "5000",
+	}
+	if got := readArgs(t, args); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got arguments %q, want %q", got, want)
+	}
+	if lastID != 42 {
+		t.Errorf("got notification id %d, want 42", lastID)
+	}
+}
+
+func TestSendReplacesLastNotification(t *testing.T) {
+	args := installGdbus(t)
+
+	t.Setenv("GDBUS_REPLY", "(uint32 7,)")
+	if err := Send("first", "", UrgencyLow); err != nil {
+		t.Fatalf("error sending notification: %v", err)
+	}
+
+	t.Setenv("GDBUS_REPLY", "(uint32 8,)")
+	if err := Send("second", "", UrgencyLow); err != nil {
+		t.Fatalf("error sending notification: %v", err)
+	}
+
+	if got := readArgs(t, args)[9]; got != "7" {
+		t.Errorf("got replaced id %s, want 7", got)
+	}
+	if lastID != 8 {
+		t.Errorf("got notification id %d, want 8", lastID)
+	}
+}
+
+func TestSendUnexpectedReply(t *testing.T) {
+	installGdbus(t)
+	t.Setenv("GDBUS_REPLY", "()")
+
+	if err := Send("summary", "body", UrgencyNormal); err == nil {
+		t.Error("expected an error for an unexpected reply")
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	installGdbus(t)
+	t.Setenv("GDBUS_REPLY", "Error: GDBus.Error:org.freedesktop.DBus.Error.ServiceUnknown")
+	t.Setenv("GDBUS_FAIL", "1")
+
+	err := Send("summary", "body", UrgencyNormal)
+	if err == nil || !strings.Contains(err.Error(), "ServiceUnknown") {
+		t.Errorf("expected the gdbus output in the error, got %v", err)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", "''"},
+		{"home", "'home'"},
+		{"it's", `'it\'s'`},
+		{`C:\path`, `'C:\\path'`},
+		{"a\nb", `'a\nb'`},
+		{`\'`, `'\\\''`},
+	}
+
+	for _, test := range tests {
+		if got := quote(test.text); got != test.want {
+			t.Errorf("quote(%q) = %s, want %s", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listen binds a datagram socket standing in for the service manager and
+// points NOTIFY_SOCKET to it.
+func listen(t *testing.T, address, env string) *net.UnixConn {
+	t.Helper()
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: address, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("error listening on %q: %v", address, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	t.Setenv("NOTIFY_SOCKET", env)
+	return conn
+}
+
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("error reading notification: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn := listen(t, path, path)
+
+	tests := []struct {
+		send func() error
+		want string
+	}{
+		{Ready, "READY=1"},
+		{func() error { return Status("Active profile: home") }, "STATUS=Active profile: home"},
+		{Watchdog, "WATCHDOG=1"},
+	}
+
+	for _, test := range tests {
+		if err := test.send(); err != nil {
+			t.Fatalf("error sending %q: %v", test.want, err)
+		}
+		if got := receive(t, conn); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestNotifyAbstractSocket(t *testing.T) {
+	name := fmt.Sprintf("@i3-autodisplay-test-%d", os.Getpid())
+	conn := listen(t, name, name)
+
+	if err := Ready(); err != nil {
+		t.Fatalf("error sending READY=1: %v", err)
+	}
+	if got := receive(t, conn); got != "READY=1" {
+		t.Errorf("got %q, want %q", got, "READY=1")
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	if err := Ready(); err != nil {
+		t.Errorf("expected no error outside of systemd, got %v", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{"", "", 0},
+		{"invalid", "", 0},
+		{"0", "", 0},
+		{"10000000", "", 5 * time.Second},
+		{"10000000", pid, 5 * time.Second},
+		{"10000000", "1", 0},
+	}
+
+	for _, test := range tests {
+		t.Setenv("WATCHDOG_USEC", test.usec)
+		t.Setenv("WATCHDOG_PID", test.pid)
+
+		if got := WatchdogInterval(); got != test.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: got %s, want %s", test.usec, test.pid, got, test.want)
+		}
+	}
+}